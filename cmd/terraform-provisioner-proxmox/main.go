@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/hashicorp/terraform/plugin"
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/wearespindle/terraform-provider-proxmox/provisioner"
+)
+
+func main() {
+	plugin.Serve(&plugin.ServeOpts{
+		ProvisionerFunc: func() terraform.ResourceProvisioner {
+			return provisioner.Provisioner()
+		},
+	})
+}
@@ -51,7 +51,11 @@ resource "proxmox_vm_qemu" "cloudinit-test" {
 
     # Setup the ip address using cloud-init.
     # Keep in mind to use the CIDR notation for the ip.
-    ipconfig0 = "ip=192.168.10.20/24,gw=192.168.10.1"
+    ipconfig {
+        id = 0
+        ipv4 = "192.168.10.20/24"
+        gw = "192.168.10.1"
+    }
 
     sshkeys = <<EOF
     ssh-rsa 9182739187293817293817293871== user@pc
@@ -4,5 +4,7 @@ go 1.14
 
 require (
 	github.com/Telmate/proxmox-api-go v0.0.0-20191217000250-7338ae30b9b0
+	github.com/hashicorp/terraform v0.12.20
 	github.com/hashicorp/terraform-plugin-sdk v1.7.0
+	golang.org/x/crypto v0.0.0-20190820162420-60c769a6c586
 )
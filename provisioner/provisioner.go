@@ -0,0 +1,198 @@
+// Package provisioner implements the proxmox provisioner.
+//
+// terraform-plugin-sdk only serves providers, so the provisioner is built on
+// the helper/schema package of Terraform itself. The SDK and Terraform cannot
+// be linked into one binary, which is why this package does not share the
+// API session of the provider and talks to Proxmox with pxapi only.
+package provisioner
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// Actions of the proxmox provisioner, see docs/provisioner.md.
+const (
+	actionSshBackward = "sshbackward"
+	actionReconnect   = "reconnect"
+)
+
+// The id of a proxmox_vm_qemu, like pve/qemu/100.
+var rxResourceId = regexp.MustCompile(`^([^/]+)/([^/]+)/([0-9]+)$`)
+
+// Provisioner - Terraform properties for the proxmox provisioner
+func Provisioner() terraform.ResourceProvisioner {
+	return &schema.Provisioner{
+		// Terraform 0.12 only passes the provisioner block and the
+		// connection to provisioners, not the resource they run for, so
+		// the VM and the API are arguments of the provisioner.
+		Schema: map[string]*schema.Schema{
+			"action": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ValidateFunc: validation.StringInSlice([]string{actionSshBackward, actionReconnect}, false),
+			},
+			"resource_id": {
+				Type:         schema.TypeString,
+				Required:     true,
+				Description:  "id of the proxmox_vm_qemu, like self.id",
+				ValidateFunc: validation.StringMatch(rxResourceId, "must be node/type/vmId"),
+			},
+			"net1": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"pm_api_url": {
+				Type:        schema.TypeString,
+				Required:    true,
+				DefaultFunc: schema.EnvDefaultFunc("PM_API_URL", nil),
+				Description: "https://host.fqdn:8006/api2/json",
+			},
+			"pm_user": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("PM_USER", nil),
+			},
+			"pm_password": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("PM_PASS", nil),
+				Sensitive:   true,
+			},
+			"pm_otp": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("PM_OTP", ""),
+			},
+			"pm_api_token_id": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("PM_API_TOKEN_ID", nil),
+			},
+			"pm_api_token_secret": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("PM_API_TOKEN_SECRET", nil),
+				Sensitive:   true,
+			},
+			"pm_tls_insecure": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+		},
+		ApplyFunc: applyFn,
+	}
+}
+
+func applyFn(ctx context.Context) error {
+	d := ctx.Value(schema.ProvConfigDataKey).(*schema.ResourceData)
+
+	vmr, err := vmRef(d.Get("resource_id").(string))
+	if err != nil {
+		return err
+	}
+	client, err := getClient(d)
+	if err != nil {
+		return err
+	}
+	return provisionVm(client, vmr, d.Get("action").(string), d.Get("net1").(string))
+}
+
+func vmRef(resourceId string) (*pxapi.VmRef, error) {
+	match := rxResourceId.FindStringSubmatch(resourceId)
+	if match == nil {
+		return nil, fmt.Errorf("Invalid resource format: %s. Must be node/type/vmId", resourceId)
+	}
+	vmId, err := strconv.Atoi(match[3])
+	if err != nil {
+		return nil, err
+	}
+	vmr := pxapi.NewVmRef(vmId)
+	vmr.SetNode(match[1])
+	vmr.SetVmType(match[2])
+	return vmr, nil
+}
+
+// getClient logs in like the provider does, with a ticket for pm_user or
+// with an API token.
+func getClient(d *schema.ResourceData) (*pxapi.Client, error) {
+	tlsconf := &tls.Config{InsecureSkipVerify: d.Get("pm_tls_insecure").(bool)}
+	tokenID := d.Get("pm_api_token_id").(string)
+	tokenSecret := d.Get("pm_api_token_secret").(string)
+	if tokenID != "" || tokenSecret != "" {
+		if tokenID == "" || tokenSecret == "" {
+			return nil, errors.New("Both pm_api_token_id and pm_api_token_secret must be set to use API token authentication")
+		}
+		// pxapi has no API tokens, they are sent with every request.
+		httpClient := &http.Client{Transport: &apiTokenTransport{
+			token: tokenID + "=" + tokenSecret,
+			base:  &http.Transport{TLSClientConfig: tlsconf, DisableCompression: true},
+		}}
+		return pxapi.NewClient(d.Get("pm_api_url").(string), httpClient, nil)
+	}
+
+	user := d.Get("pm_user").(string)
+	password := d.Get("pm_password").(string)
+	if user == "" || password == "" {
+		return nil, errors.New("Either pm_user and pm_password, or pm_api_token_id and pm_api_token_secret must be set")
+	}
+	client, err := pxapi.NewClient(d.Get("pm_api_url").(string), nil, tlsconf)
+	if err != nil {
+		return nil, err
+	}
+	err = client.Login(user, password, d.Get("pm_otp").(string))
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+type apiTokenTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t *apiTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "PVEAPIToken="+t.token)
+	return t.base.RoundTrip(req)
+}
+
+// provisionVm runs a provisioner action on a VM of proxmox_vm_qemu.
+func provisionVm(client *pxapi.Client, vmr *pxapi.VmRef, action string, net1 string) error {
+	if action == actionReconnect && net1 == "" {
+		return errors.New("net1 must be set for the reconnect action")
+	}
+	err := client.CheckVmRef(vmr)
+	if err != nil {
+		return err
+	}
+	// The temporary adapter is hot plugged through the QEMU monitor, it is
+	// not part of the VM config and the removal is synchronous. It has to be
+	// gone before a persistent net1 can be hot plugged.
+	err = pxapi.RemoveSshForwardUsernet(vmr, client)
+	if err != nil || action == actionSshBackward {
+		return err
+	}
+	// SetVmConfig waits up to pxapi's TaskTimeout for the config task, but
+	// loses the error of a failed task, which leaves the exit status empty.
+	exitStatus, err := client.SetVmConfig(vmr, map[string]interface{}{"net1": net1})
+	if err != nil {
+		return err
+	}
+	if exitStatus != "OK" {
+		return fmt.Errorf("Error setting net1 of VM %d, see the task log on node %s", vmr.VmId(), vmr.Node())
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package provisioner
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func TestProvisioner(t *testing.T) {
+	if err := Provisioner().(*schema.Provisioner).InternalValidate(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestVmRef(t *testing.T) {
+	vmr, err := vmRef("pve/qemu/100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vmr.Node() != "pve" || vmr.GetVmType() != "qemu" || vmr.VmId() != 100 {
+		t.Errorf("expected pve/qemu/100, got %s/%s/%d", vmr.Node(), vmr.GetVmType(), vmr.VmId())
+	}
+	if _, err := vmRef("100"); err == nil {
+		t.Error("expected an error for a VMID without node and type")
+	}
+}
+
+// newVmServer fakes the API of a running VM 100 on node pve, whose config
+// tasks end with exitStatus. It records the requests and the monitor commands.
+func newVmServer(exitStatus string) (*httptest.Server, *[]string) {
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		r.ParseForm()
+		request := r.Method + " " + strings.TrimPrefix(r.URL.Path, "/api2/json")
+		if command := r.PostForm.Get("command"); command != "" {
+			request += " " + command
+		}
+		if net1 := r.PostForm.Get("net1"); net1 != "" {
+			request += " " + net1
+		}
+		requests = append(requests, request)
+
+		var data interface{}
+		switch r.Method + " " + strings.TrimPrefix(r.URL.Path, "/api2/json") {
+		case "GET /nodes/pve/qemu/100/status/current":
+			data = map[string]interface{}{"status": "running"}
+		case "POST /nodes/pve/qemu/100/monitor":
+			data = ""
+		case "POST /nodes/pve/qemu/100/config":
+			data = "UPID:pve:0000C1F1:0001A2B3:5E0F1D2C:qmconfig:100:root@pam:"
+		case "GET /nodes/pve/tasks/UPID:pve:0000C1F1:0001A2B3:5E0F1D2C:qmconfig:100:root@pam:/status":
+			data = map[string]interface{}{"status": "stopped", "exitstatus": exitStatus}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	return server, &requests
+}
+
+func TestProvisionVm(t *testing.T) {
+	removeForward := []string{
+		"GET /nodes/pve/qemu/100/status/current",
+		"POST /nodes/pve/qemu/100/monitor device_del net1",
+		"POST /nodes/pve/qemu/100/monitor netdev_del net1",
+	}
+	tests := []struct {
+		name       string
+		action     string
+		net1       string
+		exitStatus string
+		expected   []string
+		fails      bool
+	}{
+		{"sshbackward", actionSshBackward, "", "OK", removeForward, false},
+		{
+			"reconnect",
+			actionReconnect,
+			"virtio,bridge=vmbr0",
+			"OK",
+			append(removeForward,
+				"POST /nodes/pve/qemu/100/config virtio,bridge=vmbr0",
+				"GET /nodes/pve/tasks/UPID:pve:0000C1F1:0001A2B3:5E0F1D2C:qmconfig:100:root@pam:/status",
+			),
+			false,
+		},
+		{
+			"reconnect failed",
+			actionReconnect,
+			"virtio,bridge=vmbr9",
+			"bridge 'vmbr9' does not exist",
+			append(removeForward,
+				"POST /nodes/pve/qemu/100/config virtio,bridge=vmbr9",
+				"GET /nodes/pve/tasks/UPID:pve:0000C1F1:0001A2B3:5E0F1D2C:qmconfig:100:root@pam:/status",
+			),
+			true,
+		},
+		{"reconnect without net1", actionReconnect, "", "OK", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, requests := newVmServer(test.exitStatus)
+			defer server.Close()
+			client, err := pxapi.NewClient(server.URL+"/api2/json", nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vmr, _ := vmRef("pve/qemu/100")
+
+			err = provisionVm(client, vmr, test.action, test.net1)
+			if (err != nil) != test.fails {
+				t.Errorf("expected failure %v, got %v", test.fails, err)
+			}
+			// The server is closed, so all requests are recorded.
+			server.Close()
+			if !reflect.DeepEqual(*requests, test.expected) {
+				t.Errorf("expected requests %q, got %q", test.expected, *requests)
+			}
+		})
+	}
+}
+
+func TestApiTokenTransport(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"status": "running"}})
+	}))
+	defer server.Close()
+	raw := map[string]interface{}{
+		"action":              actionSshBackward,
+		"resource_id":         "pve/qemu/100",
+		"pm_api_url":          server.URL + "/api2/json",
+		"pm_api_token_id":     "terraform@pve!provisioner",
+		"pm_api_token_secret": "5f2e0c4a",
+	}
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, raw)
+
+	client, err := getClient(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vmr, _ := vmRef("pve/qemu/100")
+	if _, err := client.GetVmState(vmr); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "PVEAPIToken=terraform@pve!provisioner=5f2e0c4a"; authorization != expected {
+		t.Errorf("expected Authorization %q, got %q", expected, authorization)
+	}
+}
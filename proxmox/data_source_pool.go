@@ -0,0 +1,86 @@
+package proxmox
+
+import (
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
+)
+
+func dataSourcePool() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourcePoolRead,
+
+		Schema: map[string]*schema.Schema{
+			"poolid": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ValidateFunc: validation.StringMatch(rxPoolId, "must only contain letters, digits, _, . and -"),
+			},
+			"comment": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"members": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"id": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"type": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"node": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"vmid": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"name": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"storage": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func dataSourcePoolRead(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	poolID := d.Get("poolid").(string)
+	resp, err := pconf.Session.Get(poolPath(poolID), nil)
+	if err != nil {
+		return err
+	}
+	pool, _ := resp["data"].(map[string]interface{})
+	comment, _ := pool["comment"].(string)
+
+	var members []interface{}
+	rawMembers, _ := pool["members"].([]interface{})
+	for _, rawMember := range rawMembers {
+		rawMember, _ := rawMember.(map[string]interface{})
+		member := map[string]interface{}{}
+		for _, key := range []string{"id", "type", "node", "name", "storage"} {
+			member[key], _ = rawMember[key].(string)
+		}
+		// Only VMs and containers have a VMID.
+		vmID, _ := rawMember["vmid"].(float64)
+		member["vmid"] = int(vmID)
+		members = append(members, member)
+	}
+
+	d.SetId(poolID)
+	d.Set("comment", comment)
+	d.Set("members", members)
+	return nil
+}
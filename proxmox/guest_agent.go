@@ -0,0 +1,127 @@
+package proxmox
+
+import (
+	"log"
+	"net"
+	"time"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+const (
+	// Time between two checks for the addresses reported by the guest agent.
+	agentPollInterval = 5 * time.Second
+	// Longest time Create waits for the guest agent to report an address, so
+	// the create timeout leaves time for the preprovision phase.
+	agentWaitTimeout = 5 * time.Minute
+)
+
+// defaultAddresses returns the first IPv4 and IPv6 address reported by the
+// guest agent which are neither loopback nor link local.
+func defaultAddresses(ifs []pxapi.AgentNetworkInterface) (ipv4 string, ipv6 string) {
+	for _, iface := range ifs {
+		for _, ip := range iface.IPAddresses {
+			if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+				continue
+			}
+			if ip.To4() != nil {
+				if ipv4 == "" {
+					ipv4 = ip.String()
+				}
+			} else if ipv6 == "" {
+				ipv6 = ip.String()
+			}
+		}
+	}
+	return ipv4, ipv6
+}
+
+// ipconfigAddresses returns the static addresses of a cloud-init ipconfig,
+// like ip=10.0.0.2/24,gw=10.0.0.1,ip6=2001:db8::2/64.
+func ipconfigAddresses(ipconfig string) (ipv4 string, ipv6 string) {
+	for _, match := range rxIPconfig.FindAllStringSubmatch(ipconfig, -1) {
+		ip := net.ParseIP(match[1])
+		if ip == nil {
+			// ip=dhcp or ip6=auto
+			continue
+		}
+		if ip.To4() != nil {
+			ipv4 = ip.String()
+		} else {
+			ipv6 = ip.String()
+		}
+	}
+	return ipv4, ipv6
+}
+
+func flattenNetworkInterfaces(ifs []pxapi.AgentNetworkInterface) []map[string]interface{} {
+	flattened := make([]map[string]interface{}, 0, len(ifs))
+	for _, iface := range ifs {
+		addresses := make([]string, 0, len(iface.IPAddresses))
+		for _, ip := range iface.IPAddresses {
+			addresses = append(addresses, ip.String())
+		}
+		flattened = append(flattened, map[string]interface{}{
+			"name":         iface.Name,
+			"mac_address":  iface.MACAddress,
+			"ip_addresses": addresses,
+		})
+	}
+	return flattened
+}
+
+// readGuestAddresses sets the address attributes from the guest agent. When
+// the agent is disabled or does not answer, the static addresses of ipconfig0
+// are used instead.
+func readGuestAddresses(pconf *providerConfiguration, d *schema.ResourceData, vmr *pxapi.VmRef) {
+	var ifs []pxapi.AgentNetworkInterface
+	if d.Get("agent").(int) == 1 {
+		var err error
+		ifs, err = pconf.Client.GetVmAgentNetworkInterfaces(vmr)
+		if err != nil {
+			// The VM may be stopped or the agent not running (yet).
+			log.Printf("[DEBUG] could not read network interfaces from guest agent of VM %d: %v", vmr.VmId(), err)
+			ifs = nil
+		}
+	}
+	ipv4, ipv6 := defaultAddresses(ifs)
+	staticIpv4, staticIpv6 := ipconfigAddresses(primaryIpconfig(d))
+	if ipv4 == "" {
+		ipv4 = staticIpv4
+	}
+	if ipv6 == "" {
+		ipv6 = staticIpv6
+	}
+	d.Set("default_ipv4_address", ipv4)
+	d.Set("default_ipv6_address", ipv6)
+	d.Set("network_interfaces", flattenNetworkInterfaces(ifs))
+}
+
+// waitForGuestAddress waits until the guest agent reports an address which is
+// neither loopback nor link local, for at most agentWaitTimeout or until the
+// deadline. The VM is left alone when it gives up, as the address attributes
+// then fall back to ipconfig0.
+func waitForGuestAddress(pconf *providerConfiguration, vmr *pxapi.VmRef, deadline time.Time) {
+	if waitDeadline := time.Now().Add(agentWaitTimeout); waitDeadline.Before(deadline) {
+		deadline = waitDeadline
+	}
+	for {
+		ifs, err := pconf.Client.GetVmAgentNetworkInterfaces(vmr)
+		if err == nil {
+			if ipv4, ipv6 := defaultAddresses(ifs); ipv4 != "" || ipv6 != "" {
+				return
+			}
+		}
+		if time.Now().Add(agentPollInterval).After(deadline) {
+			if err != nil {
+				log.Printf("[WARN] guest agent of VM %d did not answer in time, using the addresses of ipconfig0: %v", vmr.VmId(), err)
+			} else {
+				log.Printf("[WARN] guest agent of VM %d did not report an address in time, using the addresses of ipconfig0", vmr.VmId())
+			}
+			return
+		}
+		log.Printf("[DEBUG] waiting for guest agent of VM %d to report an address", vmr.VmId())
+		time.Sleep(agentPollInterval)
+	}
+}
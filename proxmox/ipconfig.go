@@ -0,0 +1,187 @@
+package proxmox
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+// expandIpconfig serializes an ipconfig block to the format of Proxmox, like
+// ip=10.0.0.2/24,gw=10.0.0.1,ip6=auto.
+func expandIpconfig(ipconfig map[string]interface{}) (string, error) {
+	var parts []string
+	ipv4 := ipconfig["ipv4"].(string)
+	if ipconfig["dhcp"].(bool) {
+		if ipv4 != "" {
+			return "", fmt.Errorf("ipconfig %d: ipv4 and dhcp cannot both be set", ipconfig["id"].(int))
+		}
+		parts = append(parts, "ip=dhcp")
+	} else if ipv4 != "" {
+		parts = append(parts, "ip="+ipv4)
+	}
+	if gw := ipconfig["gw"].(string); gw != "" {
+		parts = append(parts, "gw="+gw)
+	}
+	if ipv6 := ipconfig["ipv6"].(string); ipv6 != "" {
+		parts = append(parts, "ip6="+ipv6)
+	}
+	if gw6 := ipconfig["gw6"].(string); gw6 != "" {
+		parts = append(parts, "gw6="+gw6)
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// flattenIpconfig parses an ipconfig of Proxmox into an ipconfig block.
+func flattenIpconfig(id int, ipconfig string) map[string]interface{} {
+	block := map[string]interface{}{
+		"id":   id,
+		"ipv4": "",
+		"gw":   "",
+		"ipv6": "",
+		"gw6":  "",
+		"dhcp": false,
+	}
+	for _, part := range strings.Split(ipconfig, ",") {
+		keyValue := strings.SplitN(part, "=", 2)
+		if len(keyValue) != 2 {
+			continue
+		}
+		switch value := keyValue[1]; keyValue[0] {
+		case "ip":
+			if value == "dhcp" {
+				block["dhcp"] = true
+			} else {
+				block["ipv4"] = value
+			}
+		case "gw":
+			block["gw"] = value
+		case "ip6":
+			block["ipv6"] = value
+		case "gw6":
+			block["gw6"] = value
+		}
+	}
+	return block
+}
+
+// expandIpconfigs returns the ipconfigN parameters of the ipconfig blocks. The
+// ipconfigN keys of blocks which were removed are deleted.
+func expandIpconfigs(d *schema.ResourceData) (map[string]interface{}, error) {
+	params := map[string]interface{}{}
+	ids := map[int]bool{}
+	for _, raw := range d.Get("ipconfig").(*schema.Set).List() {
+		ipconfig := raw.(map[string]interface{})
+		id := ipconfig["id"].(int)
+		if ids[id] {
+			return nil, fmt.Errorf("Duplicate ipconfig id: %d", id)
+		}
+		ids[id] = true
+		value, err := expandIpconfig(ipconfig)
+		if err != nil {
+			return nil, err
+		}
+		params[fmt.Sprintf("ipconfig%d", id)] = value
+	}
+
+	oldIpconfigs, _ := d.GetChange("ipconfig")
+	var deleted []string
+	for _, raw := range oldIpconfigs.(*schema.Set).List() {
+		if id := raw.(map[string]interface{})["id"].(int); !ids[id] {
+			deleted = append(deleted, fmt.Sprintf("ipconfig%d", id))
+		}
+	}
+	if len(deleted) > 0 {
+		sort.Strings(deleted)
+		params["delete"] = strings.Join(deleted, ",")
+	}
+	return params, nil
+}
+
+// flattenIpconfigs reads the ipconfigN keys of a VM config into ipconfig
+// blocks.
+func flattenIpconfigs(vmConfig map[string]interface{}) []interface{} {
+	var ipconfigs []interface{}
+	for key, value := range vmConfig {
+		if !strings.HasPrefix(key, "ipconfig") {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(key, "ipconfig"))
+		if err != nil {
+			continue
+		}
+		if ipconfig, ok := value.(string); ok {
+			ipconfigs = append(ipconfigs, flattenIpconfig(id, ipconfig))
+		}
+	}
+	return ipconfigs
+}
+
+// usesDeprecatedIpconfigs tells whether the state has the ipconfig0 to
+// ipconfig2 attributes rather than ipconfig blocks. Read then keeps to those.
+func usesDeprecatedIpconfigs(d *schema.ResourceData) bool {
+	if readsIpconfigBlocks(d) {
+		return false
+	}
+	for _, key := range []string{"ipconfig0", "ipconfig1", "ipconfig2"} {
+		if d.Get(key).(string) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// readsIpconfigBlocks tells whether Read sets the ipconfig blocks, which it
+// only does when the config or the state has some, like after an import. The
+// ipconfigN of a VM without blocks, like those a clone gets from its template,
+// are left alone, as reading them would make the next plan delete them.
+func readsIpconfigBlocks(d *schema.ResourceData) bool {
+	return d.Get("ipconfig").(*schema.Set).Len() > 0
+}
+
+// importIpconfigs imports a VM with every ipconfigN of its config as ipconfig
+// blocks, which Read refreshes from then on.
+func importIpconfigs(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	pconf := meta.(*providerConfiguration)
+	_, _, vmID, err := parseResourceId(d.Id())
+	if err != nil {
+		return nil, err
+	}
+	vmConfig, err := pconf.Client.GetVmConfig(pxapi.NewVmRef(vmID))
+	if err != nil {
+		return nil, err
+	}
+	d.Set("ipconfig", flattenIpconfigs(vmConfig))
+	return []*schema.ResourceData{d}, nil
+}
+
+// updateIpconfigs writes the parameters of expandIpconfigs to the VM config,
+// pxapi only knows about ipconfig0 to ipconfig2.
+func updateIpconfigs(pconf *providerConfiguration, vmr *pxapi.VmRef, params map[string]interface{}, timeout time.Duration) error {
+	if len(params) == 0 {
+		return nil
+	}
+	err := pconf.Client.CheckVmRef(vmr)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/nodes/%s/%s/%d/config", vmr.Node(), vmr.GetVmType(), vmr.VmId())
+	return runTask(pconf, "POST", path, params, timeout)
+}
+
+// primaryIpconfig returns ipconfig0, either of an ipconfig block or of the
+// deprecated ipconfig0 attribute.
+func primaryIpconfig(d *schema.ResourceData) string {
+	for _, raw := range d.Get("ipconfig").(*schema.Set).List() {
+		ipconfig := raw.(map[string]interface{})
+		if ipconfig["id"].(int) == 0 {
+			value, _ := expandIpconfig(ipconfig)
+			return value
+		}
+	}
+	return d.Get("ipconfig0").(string)
+}
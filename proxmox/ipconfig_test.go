@@ -0,0 +1,289 @@
+package proxmox
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+)
+
+func testIpconfig(id int, ipv4, gw, ipv6, gw6 string, dhcp bool) map[string]interface{} {
+	return map[string]interface{}{
+		"id":   id,
+		"ipv4": ipv4,
+		"gw":   gw,
+		"ipv6": ipv6,
+		"gw6":  gw6,
+		"dhcp": dhcp,
+	}
+}
+
+func TestExpandIpconfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		ipconfig map[string]interface{}
+		expected string
+		valid    bool
+	}{
+		{"dhcp", testIpconfig(0, "", "", "", "", true), "ip=dhcp", true},
+		{"static", testIpconfig(0, "10.0.0.2/24", "10.0.0.1", "", "", false), "ip=10.0.0.2/24,gw=10.0.0.1", true},
+		{"ipv6", testIpconfig(1, "", "", "2001:db8::2/64", "2001:db8::1", false), "ip6=2001:db8::2/64,gw6=2001:db8::1", true},
+		{"dual stack", testIpconfig(0, "", "", "auto", "", true), "ip=dhcp,ip6=auto", true},
+		{"empty", testIpconfig(2, "", "", "", "", false), "", true},
+		{"dhcp and ipv4", testIpconfig(0, "10.0.0.2/24", "", "", "", true), "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ipconfig, err := expandIpconfig(test.ipconfig)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("expected an error, got %q", ipconfig)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ipconfig != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, ipconfig)
+			}
+		})
+	}
+}
+
+func TestFlattenIpconfig(t *testing.T) {
+	tests := []struct {
+		ipconfig string
+		expected map[string]interface{}
+	}{
+		{"ip=dhcp", testIpconfig(0, "", "", "", "", true)},
+		{"ip=10.0.0.2/24,gw=10.0.0.1", testIpconfig(0, "10.0.0.2/24", "10.0.0.1", "", "", false)},
+		{"ip6=2001:db8::2/64,gw6=2001:db8::1", testIpconfig(0, "", "", "2001:db8::2/64", "2001:db8::1", false)},
+		{"ip=dhcp,ip6=auto", testIpconfig(0, "", "", "auto", "", true)},
+		{"ip=dhcp,unknown,foo=bar", testIpconfig(0, "", "", "", "", true)},
+		{"", testIpconfig(0, "", "", "", "", false)},
+	}
+	for _, test := range tests {
+		block := flattenIpconfig(0, test.ipconfig)
+		if !reflect.DeepEqual(block, test.expected) {
+			t.Errorf("flattenIpconfig(%q): expected %v, got %v", test.ipconfig, test.expected, block)
+		}
+		// Every known option survives a round trip.
+		if test.ipconfig != "ip=dhcp,unknown,foo=bar" {
+			if ipconfig, _ := expandIpconfig(block); ipconfig != test.ipconfig {
+				t.Errorf("expandIpconfig(flattenIpconfig(%q)): got %q", test.ipconfig, ipconfig)
+			}
+		}
+	}
+}
+
+func TestFlattenIpconfigs(t *testing.T) {
+	vmConfig := map[string]interface{}{
+		"name":      "web-1",
+		"ipconfig0": "ip=dhcp",
+		"ipconfig5": "ip=10.0.0.2/24,gw=10.0.0.1",
+		"ipconfigx": "ip=dhcp",
+		"memory":    float64(2048),
+	}
+	ipconfigs := flattenIpconfigs(vmConfig)
+	if len(ipconfigs) != 2 {
+		t.Fatalf("expected 2 ipconfig blocks, got %v", ipconfigs)
+	}
+	byId := map[int]map[string]interface{}{}
+	for _, raw := range ipconfigs {
+		ipconfig := raw.(map[string]interface{})
+		byId[ipconfig["id"].(int)] = ipconfig
+	}
+	if !reflect.DeepEqual(byId[0], testIpconfig(0, "", "", "", "", true)) {
+		t.Errorf("ipconfig 0: got %v", byId[0])
+	}
+	if !reflect.DeepEqual(byId[5], testIpconfig(5, "10.0.0.2/24", "10.0.0.1", "", "", false)) {
+		t.Errorf("ipconfig 5: got %v", byId[5])
+	}
+}
+
+func TestExpandIpconfigs(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceQemuSchema, map[string]interface{}{
+		"name":        "web-1",
+		"target_node": "pve",
+		"ipconfig": []interface{}{
+			testIpconfig(0, "", "", "", "", true),
+			testIpconfig(3, "10.0.0.2/24", "10.0.0.1", "", "", false),
+		},
+	})
+	params, err := expandIpconfigs(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"ipconfig0": "ip=dhcp",
+		"ipconfig3": "ip=10.0.0.2/24,gw=10.0.0.1",
+	}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("expected %v, got %v", expected, params)
+	}
+}
+
+func TestExpandIpconfigsDeleted(t *testing.T) {
+	// The state has ipconfig 0 and 1, the config only keeps ipconfig 0.
+	old := schema.TestResourceDataRaw(t, resourceQemuSchema, map[string]interface{}{
+		"name":        "web-1",
+		"target_node": "pve",
+		"ipconfig": []interface{}{
+			testIpconfig(0, "", "", "", "", true),
+			testIpconfig(1, "10.0.1.2/24", "", "", "", false),
+		},
+	})
+	old.SetId("pve/qemu/100")
+	state := old.State()
+	resource := &schema.Resource{Schema: resourceQemuSchema}
+	config := terraform.NewResourceConfigRaw(map[string]interface{}{
+		"name":        "web-1",
+		"target_node": "pve",
+		"ipconfig":    []interface{}{testIpconfig(0, "", "", "", "", true)},
+	})
+	diff, err := resource.Diff(state, config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := schema.InternalMap(resourceQemuSchema).Data(state, diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := expandIpconfigs(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params["ipconfig0"] != "ip=dhcp" || params["delete"] != "ipconfig1" {
+		t.Errorf("expected ipconfig0 to be set and ipconfig1 deleted, got %v", params)
+	}
+}
+
+func TestExpandIpconfigsDuplicateId(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceQemuSchema, map[string]interface{}{
+		"name":        "web-1",
+		"target_node": "pve",
+		"ipconfig": []interface{}{
+			testIpconfig(0, "", "", "", "", true),
+			testIpconfig(0, "10.0.0.2/24", "", "", "", false),
+		},
+	})
+	if _, err := expandIpconfigs(d); err == nil {
+		t.Error("expected an error for a duplicate ipconfig id")
+	}
+}
+
+func TestUsesDeprecatedIpconfigs(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      map[string]interface{}
+		expected bool
+	}{
+		{"nothing set", map[string]interface{}{}, false},
+		{"ipconfig0", map[string]interface{}{"ipconfig0": "ip=dhcp"}, true},
+		{"ipconfig2", map[string]interface{}{"ipconfig2": "ip=dhcp"}, true},
+		{"ipconfig block", map[string]interface{}{"ipconfig": []interface{}{testIpconfig(0, "", "", "", "", true)}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.raw["name"] = "web-1"
+			test.raw["target_node"] = "pve"
+			d := schema.TestResourceDataRaw(t, resourceQemuSchema, test.raw)
+			if deprecated := usesDeprecatedIpconfigs(d); deprecated != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, deprecated)
+			}
+		})
+	}
+}
+
+// newIpconfigVmServer fakes VM 100 of a clone, which got ipconfig0 from its
+// template, and the provider configuration to read it.
+func newIpconfigVmServer(t *testing.T) (*fakeApiServer, *providerConfiguration) {
+	server := newFakeApiServer()
+	server.handle("GET /cluster/resources", func(w http.ResponseWriter, r *http.Request) {
+		writeApiData(w, []interface{}{
+			map[string]interface{}{"vmid": 100, "node": "pve", "type": "qemu", "name": "web-1"},
+		})
+	})
+	server.handle("GET /nodes/pve/qemu/100/config", func(w http.ResponseWriter, r *http.Request) {
+		writeApiData(w, map[string]interface{}{
+			"name":      "web-1",
+			"memory":    2048,
+			"ipconfig0": "ip=10.0.0.2/24,gw=10.0.0.1",
+		})
+	})
+	pconf := server.config()
+	client, err := pxapi.NewClient(server.URL+"/api2/json", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pconf.Client = client
+	pconf.MaxParallel = 1
+	pconf.Mutex = &sync.Mutex{}
+	pconf.Cond = sync.NewCond(pconf.Mutex)
+	return server, pconf
+}
+
+func TestResourceVmQemuReadIpconfigs(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       map[string]interface{}
+		ipconfigs int
+		ipconfig0 string
+	}{
+		// The ipconfig0 of the template is not read into a block the next
+		// plan would remove.
+		{"no ipconfig", map[string]interface{}{}, 0, ""},
+		{"ipconfig block", map[string]interface{}{"ipconfig": []interface{}{testIpconfig(0, "", "", "", "", true)}}, 1, ""},
+		{"ipconfig0", map[string]interface{}{"ipconfig0": "ip=dhcp"}, 0, "ip=10.0.0.2/24,gw=10.0.0.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, pconf := newIpconfigVmServer(t)
+			defer server.Close()
+			test.raw["name"] = "web-1"
+			test.raw["target_node"] = "pve"
+			d := schema.TestResourceDataRaw(t, resourceQemuSchema, test.raw)
+			d.SetId("pve/qemu/100")
+
+			if err := resourceVmQemuRead(d, pconf); err != nil {
+				t.Fatal(err)
+			}
+			ipconfigs := d.Get("ipconfig").(*schema.Set).List()
+			if len(ipconfigs) != test.ipconfigs || d.Get("ipconfig0") != test.ipconfig0 {
+				t.Errorf("expected %d ipconfig blocks and ipconfig0 %q, got %v and %q", test.ipconfigs, test.ipconfig0, ipconfigs, d.Get("ipconfig0"))
+			}
+			if test.ipconfigs > 0 && !reflect.DeepEqual(ipconfigs[0], testIpconfig(0, "10.0.0.2/24", "10.0.0.1", "", "", false)) {
+				t.Errorf("expected the ipconfig0 of the VM, got %v", ipconfigs[0])
+			}
+		})
+	}
+}
+
+func TestImportIpconfigs(t *testing.T) {
+	server, pconf := newIpconfigVmServer(t)
+	defer server.Close()
+	d := resourceVmQemu().Data(nil)
+	d.SetId("pve/qemu/100")
+
+	imported, err := importIpconfigs(d, pconf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Read refreshes the blocks of an imported VM.
+	if !readsIpconfigBlocks(imported[0]) {
+		t.Fatalf("expected the ipconfig blocks of the VM, got %v", imported[0].Get("ipconfig"))
+	}
+	if err := resourceVmQemuRead(imported[0], pconf); err != nil {
+		t.Fatal(err)
+	}
+	ipconfigs := imported[0].Get("ipconfig").(*schema.Set).List()
+	if len(ipconfigs) != 1 || !reflect.DeepEqual(ipconfigs[0], testIpconfig(0, "10.0.0.2/24", "10.0.0.1", "", "", false)) {
+		t.Errorf("expected the ipconfig0 of the VM, got %v", ipconfigs)
+	}
+}
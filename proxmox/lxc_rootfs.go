@@ -0,0 +1,135 @@
+package proxmox
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
+)
+
+var lxcRootFsSchema = &schema.Schema{
+	Type:     schema.TypeList,
+	Optional: true,
+	Computed: true,
+	MaxItems: 1,
+	Elem: &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"storage": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"size": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ValidateFunc: validateDiskSize,
+			},
+			"acl": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"quota": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"replicate": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+			"ro": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"mountoptions": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringMatch(rxMountOptions, "must be a semicolon separated list of noatime, nodev, noexec and nosuid"),
+			},
+			"volume": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	},
+}
+
+// expandLxcRootFs serializes the rootfs block to the format of Proxmox. Without
+// volume, a new volume of the size is allocated on the storage, like
+// local-lvm:8. Otherwise the existing volume is used, the size is informational.
+func expandLxcRootFs(rootFs map[string]interface{}, volume string) string {
+	size, _ := parseDiskSize(rootFs["size"].(string))
+	options := []string{volume}
+	if volume == "" {
+		gigabytes := strconv.FormatFloat(float64(size)/float64(gibibyte), 'f', -1, 64)
+		options[0] = fmt.Sprintf("%s:%s", rootFs["storage"], gigabytes)
+	} else {
+		options = append(options, "size="+size.String())
+	}
+	// Only values other than the defaults of Proxmox, as acl is unset
+	// instead of false by default.
+	if rootFs["acl"].(bool) {
+		options = append(options, "acl=1")
+	}
+	if rootFs["quota"].(bool) {
+		options = append(options, "quota=1")
+	}
+	if !rootFs["replicate"].(bool) {
+		options = append(options, "replicate=0")
+	}
+	if rootFs["ro"].(bool) {
+		options = append(options, "ro=1")
+	}
+	if mountOptions := rootFs["mountoptions"].(string); mountOptions != "" {
+		options = append(options, "mountoptions="+mountOptions)
+	}
+	return strings.Join(options, ",")
+}
+
+// flattenLxcRootFs parses the rootfs of Proxmox into a rootfs block. The
+// configured size is kept when it is the same, see flattenDiskSizes.
+func flattenLxcRootFs(rootFs string, configRootFs []interface{}) []interface{} {
+	options := strings.Split(rootFs, ",")
+	volume := strings.TrimPrefix(options[0], "volume=")
+	block := map[string]interface{}{
+		"storage":      strings.SplitN(volume, ":", 2)[0],
+		"size":         "",
+		"acl":          false,
+		"quota":        false,
+		"replicate":    true,
+		"ro":           false,
+		"mountoptions": "",
+		"volume":       volume,
+	}
+	if rxNewLxcVolume.MatchString(volume) {
+		// Not allocated yet, like in the state of older versions.
+		block["volume"] = ""
+	}
+	if size, ok := lxcVolumeSize(rootFs, parseApiSize); ok {
+		block["size"] = size.String()
+		if len(configRootFs) > 0 && configRootFs[0] != nil {
+			configSize := configRootFs[0].(map[string]interface{})["size"].(string)
+			if parsed, err := parseDiskSize(configSize); err == nil && parsed == size {
+				block["size"] = configSize
+			}
+		}
+	}
+	for _, option := range options[1:] {
+		keyValue := strings.SplitN(option, "=", 2)
+		if len(keyValue) != 2 {
+			continue
+		}
+		switch key, value := keyValue[0], keyValue[1]; key {
+		case "acl", "quota", "replicate", "ro":
+			block[key] = value == "1"
+		case "mountoptions":
+			block[key] = value
+		}
+	}
+	return []interface{}{block}
+}
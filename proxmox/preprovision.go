@@ -0,0 +1,227 @@
+package proxmox
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Time between two attempts to reach a booting VM over SSH.
+	sshRetryInterval = 5 * time.Second
+	// Timeout of a single SSH connection attempt.
+	sshDialTimeout = 10 * time.Second
+)
+
+// Files the os_network_config is written to, per os_type.
+var osNetworkConfigPaths = map[string]string{
+	"ubuntu": "/etc/network/interfaces",
+	"centos": "/etc/sysconfig/network-scripts/ifcfg-eth0",
+}
+
+// preprovision makes a new VM reachable over SSH for the provisioners of the
+// resource. For Ubuntu and CentOS it also sets the hostname, writes the network
+// config and grows the root filesystem to the size of the disk.
+func preprovision(pconf *providerConfiguration, d *schema.ResourceData, vmr *pxapi.VmRef, deadline time.Time) error {
+	osType := d.Get("os_type").(string)
+	if !d.Get("preprovision").(bool) || osType == "" {
+		return nil
+	}
+	host, port := splitSshAddress(d.Get("ssh_forward_ip").(string))
+
+	switch osType {
+	case "cloud-init":
+		if host == "" {
+			// The guest agent address, or the static one of ipconfig0.
+			host = d.Get("default_ipv4_address").(string)
+		}
+		if port == "" {
+			port = "22"
+		}
+		if host != "" {
+			setSshConnInfo(d, host, port)
+		} else {
+			log.Print("[DEBUG] no ssh_forward_ip or default_ipv4_address, not setting the SSH connection")
+		}
+		// Cloud-init configures the VM itself, give it time to do so before
+		// the provisioners connect.
+		wait := time.Duration(d.Get("ci_wait").(int)) * time.Second
+		if remaining := time.Until(deadline); wait > remaining {
+			log.Printf("[WARN] only waiting %s of ci_wait for cloud-init, the create timeout expires", remaining)
+			wait = remaining
+		}
+		log.Print("[DEBUG] waiting for cloud-init")
+		time.Sleep(wait)
+		return nil
+
+	case "ubuntu", "centos":
+		if host == "" {
+			return fmt.Errorf("ssh_forward_ip is required to preprovision %s", osType)
+		}
+		if port != "" {
+			setSshConnInfo(d, host, port)
+			return preprovisionLinux(d, osType, net.JoinHostPort(host, port), deadline)
+		}
+		return preprovisionUsernet(pconf, d, vmr, osType, host, deadline)
+
+	default:
+		return fmt.Errorf("Unknown os_type: %s", osType)
+	}
+}
+
+// splitSshAddress splits the optional port off ssh_forward_ip.
+func splitSshAddress(address string) (host string, port string) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		// No port, or a bare IPv6 address.
+		return address, ""
+	}
+	return host, port
+}
+
+// preprovisionUsernet preprovisions the VM through port 22000 + vmid of the
+// node, which is forwarded to a temporary net1 adapter of the VM. The adapter
+// is removed again afterwards, so the provisioners connect to the address the
+// VM has then, if it has one.
+func preprovisionUsernet(pconf *providerConfiguration, d *schema.ResourceData, vmr *pxapi.VmRef, osType string, host string, deadline time.Time) error {
+	log.Print("[DEBUG] setting up SSH forward")
+	port, err := pxapi.SshForwardUsernet(vmr, pconf.Client)
+	if err != nil {
+		return err
+	}
+	err = preprovisionLinux(d, osType, net.JoinHostPort(host, port), deadline)
+
+	// Also after a failure, so the forward does not stay on the node.
+	log.Print("[DEBUG] removing SSH forward")
+	removeErr := pxapi.RemoveSshForwardUsernet(vmr, pconf.Client)
+	if err != nil {
+		return err
+	}
+	if removeErr != nil {
+		return removeErr
+	}
+
+	return setUsernetConnInfo(pconf, d, vmr, host)
+}
+
+// setUsernetConnInfo points the SSH connection of the provisioners at port 22
+// of the default_ipv4_address the VM has after preprovisioning. Without one,
+// the VM is only reachable through the forward, which is set up again for the
+// provisioners and left to the sshbackward action of the proxmox provisioner.
+func setUsernetConnInfo(pconf *providerConfiguration, d *schema.ResourceData, vmr *pxapi.VmRef, host string) error {
+	readGuestAddresses(pconf, d, vmr)
+	if address := d.Get("default_ipv4_address").(string); address != "" {
+		setSshConnInfo(d, address, "22")
+		return nil
+	}
+	log.Printf("[WARN] VM %d has no default_ipv4_address, keeping the SSH forward for the provisioners", vmr.VmId())
+	port, err := pxapi.SshForwardUsernet(vmr, pconf.Client)
+	if err != nil {
+		return err
+	}
+	setSshConnInfo(d, host, port)
+	return nil
+}
+
+func setSshConnInfo(d *schema.ResourceData, host string, port string) {
+	d.Set("ssh_host", host)
+	d.Set("ssh_port", port)
+	d.SetConnInfo(map[string]string{
+		"type":        "ssh",
+		"host":        host,
+		"port":        port,
+		"user":        d.Get("ssh_user").(string),
+		"private_key": d.Get("ssh_private_key").(string),
+	})
+}
+
+func preprovisionLinux(d *schema.ResourceData, osType string, address string, deadline time.Time) error {
+	client, err := dialSsh(address, d.Get("ssh_user").(string), d.Get("ssh_private_key").(string), deadline)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	name := d.Get("name").(string)
+	log.Printf("[DEBUG] setting hostname %s", name)
+	err = sshRun(client, "sudo tee /etc/hostname >/dev/null && sudo hostname "+shellQuote(name), name+"\n")
+	if err != nil {
+		return err
+	}
+
+	if networkConfig := d.Get("os_network_config").(string); networkConfig != "" {
+		configPath := osNetworkConfigPaths[osType]
+		log.Printf("[DEBUG] writing network config %s", configPath)
+		err = sshRun(client, "sudo tee "+configPath+" >/dev/null", networkConfig)
+		if err != nil {
+			return err
+		}
+		// One command, so eth0 comes up again even if the connection
+		// went through it.
+		err = sshRun(client, "sudo ifdown eth0; sudo ifup eth0", "")
+		if err != nil {
+			return err
+		}
+	}
+
+	// The script of the Telmate Ubuntu ISO builder grows the root filesystem
+	// to the disk, which may have been resized after cloning.
+	log.Print("[DEBUG] resizing root filesystem")
+	return sshRun(client, "if [ -x /etc/auto_resize_vda.sh ]; then sudo /etc/auto_resize_vda.sh; fi", "")
+}
+
+// dialSsh connects to the VM, retrying until the deadline as the VM may still
+// be booting.
+func dialSsh(address string, user string, privateKey string, deadline time.Time) (*ssh.Client, error) {
+	if user == "" || privateKey == "" {
+		return nil, errors.New("ssh_user and ssh_private_key are required to preprovision over SSH")
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ssh_private_key: %v", err)
+	}
+	config := &ssh.ClientConfig{
+		User: user,
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		// The host key of a new VM is not known up front.
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         sshDialTimeout,
+	}
+	for {
+		client, err := ssh.Dial("tcp", address, config)
+		if err == nil {
+			return client, nil
+		}
+		if time.Now().Add(sshRetryInterval).After(deadline) {
+			return nil, fmt.Errorf("Could not connect to %s over SSH: %v", address, err)
+		}
+		log.Printf("[DEBUG] waiting for SSH on %s: %v", address, err)
+		time.Sleep(sshRetryInterval)
+	}
+}
+
+// sshRun runs cmd on the VM with stdin as its input.
+func sshRun(client *ssh.Client, cmd string, stdin string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.Stdin = strings.NewReader(stdin)
+	output, err := session.CombinedOutput(cmd)
+	if err != nil {
+		return fmt.Errorf("%s failed: %v: %s", cmd, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
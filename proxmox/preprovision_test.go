@@ -0,0 +1,331 @@
+package proxmox
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshTestServer is an SSH server which records the commands it is asked to
+// run, with their input, and fails the ones containing failCommand.
+type sshTestServer struct {
+	listener    net.Listener
+	config      *ssh.ServerConfig
+	failCommand string
+
+	mu       sync.Mutex
+	commands []sshTestCommand
+}
+
+type sshTestCommand struct {
+	cmd   string
+	stdin string
+}
+
+// newSshTestServer starts a server which accepts the returned private key of
+// user.
+func newSshTestServer(t *testing.T, user string, failCommand string) (*sshTestServer, string) {
+	hostKey, _ := generateSshTestKey(t)
+	clientKey, clientPem := generateSshTestKey(t)
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == user && bytes.Equal(key.Marshal(), clientKey.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &sshTestServer{listener: listener, config: config, failCommand: failCommand}
+	go server.serve()
+	return server, clientPem
+}
+
+func generateSshTestKey(t *testing.T) (ssh.Signer, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+}
+
+func (s *sshTestServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *sshTestServer) Close() {
+	s.listener.Close()
+}
+
+func (s *sshTestServer) Commands() []sshTestCommand {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]sshTestCommand(nil), s.commands...)
+}
+
+func (s *sshTestServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *sshTestServer) handleConn(conn net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.handleSession(channel, requests)
+	}
+}
+
+func (s *sshTestServer) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			req.Reply(false, nil)
+			return
+		}
+		req.Reply(true, nil)
+
+		// The client closes stdin once it has sent all of the input.
+		stdin, _ := ioutil.ReadAll(channel)
+		s.mu.Lock()
+		s.commands = append(s.commands, sshTestCommand{payload.Command, string(stdin)})
+		s.mu.Unlock()
+
+		var status struct{ Status uint32 }
+		if s.failCommand != "" && strings.Contains(payload.Command, s.failCommand) {
+			channel.Write([]byte("permission denied"))
+			status.Status = 1
+		}
+		channel.SendRequest("exit-status", false, ssh.Marshal(&status))
+		return
+	}
+}
+
+func testPreprovisionData(t *testing.T, privateKey string, networkConfig string) *schema.ResourceData {
+	return schema.TestResourceDataRaw(t, resourceQemuSchema, map[string]interface{}{
+		"name":              "web-1",
+		"target_node":       "pve",
+		"ssh_user":          "terraform",
+		"ssh_private_key":   privateKey,
+		"os_type":           "ubuntu",
+		"os_network_config": networkConfig,
+	})
+}
+
+func TestPreprovisionLinux(t *testing.T) {
+	server, privateKey := newSshTestServer(t, "terraform", "")
+	defer server.Close()
+
+	networkConfig := "auto eth0\niface eth0 inet dhcp\n"
+	d := testPreprovisionData(t, privateKey, networkConfig)
+	err := preprovisionLinux(d, "ubuntu", server.Addr(), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("preprovisionLinux failed: %v", err)
+	}
+
+	expected := []sshTestCommand{
+		{"sudo tee /etc/hostname >/dev/null && sudo hostname 'web-1'", "web-1\n"},
+		{"sudo tee /etc/network/interfaces >/dev/null", networkConfig},
+		{"sudo ifdown eth0; sudo ifup eth0", ""},
+		{"if [ -x /etc/auto_resize_vda.sh ]; then sudo /etc/auto_resize_vda.sh; fi", ""},
+	}
+	commands := server.Commands()
+	if len(commands) != len(expected) {
+		t.Fatalf("expected %d commands, got %d: %q", len(expected), len(commands), commands)
+	}
+	for i, command := range commands {
+		if command != expected[i] {
+			t.Errorf("command %d: expected %q, got %q", i, expected[i], command)
+		}
+	}
+}
+
+func TestPreprovisionLinuxWithoutNetworkConfig(t *testing.T) {
+	server, privateKey := newSshTestServer(t, "terraform", "")
+	defer server.Close()
+
+	d := testPreprovisionData(t, privateKey, "")
+	err := preprovisionLinux(d, "centos", server.Addr(), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("preprovisionLinux failed: %v", err)
+	}
+	for _, command := range server.Commands() {
+		if strings.Contains(command.cmd, "ifcfg-eth0") || strings.Contains(command.cmd, "ifup") {
+			t.Errorf("expected no network config without os_network_config, got %q", command.cmd)
+		}
+	}
+}
+
+func TestPreprovisionLinuxCommandFails(t *testing.T) {
+	server, privateKey := newSshTestServer(t, "terraform", "hostname")
+	defer server.Close()
+
+	d := testPreprovisionData(t, privateKey, "")
+	err := preprovisionLinux(d, "ubuntu", server.Addr(), time.Now().Add(time.Minute))
+	if err == nil {
+		t.Fatal("expected an error when a command fails")
+	}
+	if !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("expected the output of the command in the error, got %v", err)
+	}
+	if commands := server.Commands(); len(commands) != 1 {
+		t.Errorf("expected preprovisioning to stop after the failed command, got %q", commands)
+	}
+}
+
+func TestDialSshWrongUser(t *testing.T) {
+	server, privateKey := newSshTestServer(t, "terraform", "")
+	defer server.Close()
+
+	// The deadline is within sshRetryInterval, so there is a single attempt.
+	_, err := dialSsh(server.Addr(), "root", privateKey, time.Now().Add(time.Second))
+	if err == nil {
+		t.Fatal("expected an error for a user the server does not accept")
+	}
+}
+
+func TestDialSshMissingCredentials(t *testing.T) {
+	_, err := dialSsh("127.0.0.1:22", "terraform", "", time.Now().Add(time.Second))
+	if err == nil {
+		t.Fatal("expected an error without ssh_private_key")
+	}
+	_, err = dialSsh("127.0.0.1:22", "terraform", "not a key", time.Now().Add(time.Second))
+	if err == nil || !strings.Contains(err.Error(), "Invalid ssh_private_key") {
+		t.Fatalf("expected an invalid key error, got %v", err)
+	}
+}
+
+func TestSplitSshAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		host    string
+		port    string
+	}{
+		{"10.0.0.1", "10.0.0.1", ""},
+		{"10.0.0.1:2222", "10.0.0.1", "2222"},
+		{"node.example.com", "node.example.com", ""},
+		{"2001:db8::1", "2001:db8::1", ""},
+		{"[2001:db8::1]:22", "2001:db8::1", "22"},
+		{"", "", ""},
+	}
+	for _, test := range tests {
+		host, port := splitSshAddress(test.address)
+		if host != test.host || port != test.port {
+			t.Errorf("splitSshAddress(%q): expected %q, %q, got %q, %q", test.address, test.host, test.port, host, port)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"web-1":     "'web-1'",
+		"it's":      `'it'\''s'`,
+		"a b; rm x": "'a b; rm x'",
+	}
+	for input, expected := range tests {
+		if quoted := shellQuote(input); quoted != expected {
+			t.Errorf("shellQuote(%q): expected %s, got %s", input, expected, quoted)
+		}
+	}
+}
+
+func TestSetUsernetConnInfo(t *testing.T) {
+	tests := []struct {
+		name      string
+		ipconfig0 string
+		host      string
+		port      string
+		forwarded bool
+	}{
+		{"address", "ip=10.0.0.5/24,gw=10.0.0.1", "10.0.0.5", "22", false},
+		// Without an address, the provisioners connect through the forward.
+		{"no address", "ip=dhcp", "10.0.0.1", "22100", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeApiServer()
+			defer server.Close()
+			server.handle("GET /nodes/pve/qemu/100/status/current", func(w http.ResponseWriter, r *http.Request) {
+				writeApiData(w, map[string]interface{}{"status": "running"})
+			})
+			server.handle("POST /nodes/pve/qemu/100/monitor", func(w http.ResponseWriter, r *http.Request) {
+				writeApiData(w, "")
+			})
+			pconf := server.config()
+			client, err := pxapi.NewClient(server.URL+"/api2/json", nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pconf.Client = client
+			vmr := pxapi.NewVmRef(100)
+			vmr.SetNode("pve")
+			vmr.SetVmType("qemu")
+			d := testPreprovisionData(t, "", "")
+			d.Set("ipconfig0", test.ipconfig0)
+
+			if err := setUsernetConnInfo(pconf, d, vmr, "10.0.0.1"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			connInfo := d.ConnInfo()
+			if connInfo["host"] != test.host || connInfo["port"] != test.port {
+				t.Errorf("expected a connection to %s:%s, got %v", test.host, test.port, connInfo)
+			}
+			if d.Get("ssh_host") != test.host || d.Get("ssh_port") != test.port {
+				t.Errorf("expected ssh_host %s and ssh_port %s, got %v and %v", test.host, test.port, d.Get("ssh_host"), d.Get("ssh_port"))
+			}
+			if forwarded := server.count("POST /nodes/pve/qemu/100/monitor") == 2; forwarded != test.forwarded {
+				t.Errorf("expected the forward to be set up: %v, got %q", test.forwarded, server.history())
+			}
+		})
+	}
+}
@@ -3,22 +3,39 @@ package proxmox
 import (
 	"crypto/tls"
 	"fmt"
+	"net/http"
 	"os"
 	"regexp"
 	"strconv"
 	"sync"
+	"time"
 
 	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
 	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
 )
 
+// Valid VMIDs according to the Proxmox API.
+const (
+	minVmId = 100
+	maxVmId = 999999999
+)
+
 type providerConfiguration struct {
 	Client          *pxapi.Client
+	Session         *apiSession
+	Retry           retryPolicy
 	MaxParallel     int
 	CurrentParallel int
+	MinVMID         int
 	MaxVMID         int
-	Mutex           *sync.Mutex
-	Cond            *sync.Cond
+	// VMIDs handed out by nextVmId for VMs which are still being created.
+	ReservedVMIDs map[int]bool
+	VmIdMutex     *sync.Mutex
+	// Serializes staging and applying network interface changes, as the
+	// reload applies the pending changes of every interface of the node.
+	NetworkMutex *sync.Mutex
+	Mutex        *sync.Mutex
+	Cond         *sync.Cond
 }
 
 // Provider - Terrafrom properties for proxmox
@@ -42,17 +59,30 @@ func Provider() *schema.Provider {
 		Schema: map[string]*schema.Schema{
 			"pm_user": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("PM_USER", nil),
 				Description: "username, maywith with @pam",
 			},
 			"pm_password": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("PM_PASS", nil),
 				Description: "secret",
 				Sensitive:   true,
 			},
+			"pm_api_token_id": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("PM_API_TOKEN_ID", nil),
+				Description: "API token id, like user@pam!tokenname",
+			},
+			"pm_api_token_secret": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("PM_API_TOKEN_SECRET", nil),
+				Description: "API token secret",
+				Sensitive:   true,
+			},
 			"pm_api_url": {
 				Type:        schema.TypeString,
 				Required:    true,
@@ -64,20 +94,91 @@ func Provider() *schema.Provider {
 				Optional: true,
 				Default:  4,
 			},
+			"pm_retry_max": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     3,
+				Description: "How often to retry API calls and tasks failing on transient errors",
+			},
+			"pm_retry_backoff": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     5,
+				Description: "Seconds to wait before the first retry, doubled for every next one",
+			},
+			"pm_vmid_range": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "Range new VMIDs are picked from",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"min": {
+							Type:     schema.TypeInt,
+							Optional: true,
+							Default:  minVmId,
+						},
+						"max": {
+							Type:     schema.TypeInt,
+							Optional: true,
+							Default:  maxVmId,
+						},
+					},
+				},
+			},
 			"pm_tls_insecure": {
 				Type:     schema.TypeBool,
 				Optional: true,
 				Default:  false,
 			},
+			"pm_tls_ca_file": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				DefaultFunc:   schema.EnvDefaultFunc("PM_TLS_CA_FILE", nil),
+				Description:   "Path to a PEM bundle of the CAs trusted to verify the API certificate",
+				ConflictsWith: []string{"pm_tls_ca_pem"},
+			},
+			"pm_tls_ca_pem": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "PEM bundle of the CAs trusted to verify the API certificate",
+				ConflictsWith: []string{"pm_tls_ca_file"},
+			},
+			"pm_tls_server_fingerprint": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("PM_TLS_SERVER_FINGERPRINT", nil),
+				Description: "SHA-256 fingerprint the API certificate must match",
+			},
+			"pm_tls_client_cert_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Path to a PEM client certificate",
+			},
+			"pm_tls_client_key_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Path to the PEM key of the client certificate",
+			},
 			"pm_otp": &pmOTPprompt,
 		},
 
 		ResourcesMap: map[string]*schema.Resource{
-			"proxmox_vm_qemu": resourceVmQemu(),
-			"proxmox_lxc":     resourceLxc(),
-			// TODO - storage_iso
-			// TODO - bridge
-			// TODO - vm_qemu_template
+			"proxmox_vm_qemu":          resourceVmQemu(),
+			"proxmox_lxc":              resourceLxc(),
+			"proxmox_storage_iso":      resourceStorageIso(),
+			"proxmox_network_bridge":   resourceNetworkBridge(),
+			"proxmox_vm_qemu_template": resourceVmQemuTemplate(),
+			"proxmox_pool":             resourcePool(),
+			"proxmox_user":             resourceUser(),
+			"proxmox_group":            resourceGroup(),
+			"proxmox_role":             resourceRole(),
+			"proxmox_acl":              resourceAcl(),
+			"proxmox_api_token":        resourceApiToken(),
+		},
+
+		DataSourcesMap: map[string]*schema.Resource{
+			"proxmox_pool": dataSourcePool(),
 		},
 
 		ConfigureFunc: providerConfigure,
@@ -85,43 +186,142 @@ func Provider() *schema.Provider {
 }
 
 func providerConfigure(d *schema.ResourceData) (interface{}, error) {
-	client, err := getClient(d.Get("pm_api_url").(string), d.Get("pm_user").(string), d.Get("pm_password").(string), d.Get("pm_otp").(string), d.Get("pm_tls_insecure").(bool))
+	pmUser := d.Get("pm_user").(string)
+	pmPassword := d.Get("pm_password").(string)
+	pmApiTokenID := d.Get("pm_api_token_id").(string)
+	pmApiTokenSecret := d.Get("pm_api_token_secret").(string)
+	if pmApiTokenID != "" || pmApiTokenSecret != "" {
+		if pmApiTokenID == "" || pmApiTokenSecret == "" {
+			return nil, fmt.Errorf("Both pm_api_token_id and pm_api_token_secret must be set to use API token authentication")
+		}
+	} else if pmUser == "" || pmPassword == "" {
+		return nil, fmt.Errorf("Either pm_user and pm_password, or pm_api_token_id and pm_api_token_secret must be set")
+	}
+
+	tlsconf, err := tlsConfig(tlsOptions{
+		Insecure:          d.Get("pm_tls_insecure").(bool),
+		CaFile:            d.Get("pm_tls_ca_file").(string),
+		CaPem:             d.Get("pm_tls_ca_pem").(string),
+		ServerFingerprint: d.Get("pm_tls_server_fingerprint").(string),
+		ClientCertFile:    d.Get("pm_tls_client_cert_file").(string),
+		ClientKeyFile:     d.Get("pm_tls_client_key_file").(string),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	retry := retryPolicy{
+		MaxRetries: d.Get("pm_retry_max").(int),
+		Backoff:    time.Duration(d.Get("pm_retry_backoff").(int)) * time.Second,
+	}
+
+	minVMID, maxVMID := minVmId, maxVmId
+	if vmidRanges := d.Get("pm_vmid_range").([]interface{}); len(vmidRanges) > 0 && vmidRanges[0] != nil {
+		vmidRange := vmidRanges[0].(map[string]interface{})
+		minVMID = vmidRange["min"].(int)
+		maxVMID = vmidRange["max"].(int)
+	}
+	if minVMID < minVmId || maxVMID > maxVmId || minVMID > maxVMID {
+		return nil, fmt.Errorf("Invalid pm_vmid_range %d-%d, it must be within %d-%d", minVMID, maxVMID, minVmId, maxVmId)
+	}
+
+	client, session, err := getClient(d.Get("pm_api_url").(string), pmUser, pmPassword, d.Get("pm_otp").(string), pmApiTokenID, pmApiTokenSecret, tlsconf, retry)
 	if err != nil {
 		return nil, err
 	}
 	var mut sync.Mutex
 	return &providerConfiguration{
 		Client:          client,
+		Session:         session,
+		Retry:           retry,
 		MaxParallel:     d.Get("pm_parallel").(int),
 		CurrentParallel: 0,
-		MaxVMID:         -1,
+		MinVMID:         minVMID,
+		MaxVMID:         maxVMID,
+		ReservedVMIDs:   map[int]bool{},
+		VmIdMutex:       &sync.Mutex{},
+		NetworkMutex:    &sync.Mutex{},
 		Mutex:           &mut,
 		Cond:            sync.NewCond(&mut),
 	}, nil
 }
 
-func getClient(pm_api_url string, pm_user string, pm_password string, pm_otp string, pm_tls_insecure bool) (*pxapi.Client, error) {
-	tlsconf := &tls.Config{InsecureSkipVerify: true}
-	if !pm_tls_insecure {
-		tlsconf = nil
+func getClient(pm_api_url string, pm_user string, pm_password string, pm_otp string, pm_api_token_id string, pm_api_token_secret string, tlsconf *tls.Config, retry retryPolicy) (*pxapi.Client, *apiSession, error) {
+	session := newApiSession(pm_api_url, tlsconf, retry)
+	if pm_api_token_id != "" {
+		// API tokens are sent with every request, there is no ticket to get.
+		session.SetApiToken(pm_api_token_id, pm_api_token_secret)
+	} else {
+		err := session.Login(pm_user, pm_password, pm_otp)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
-	client, _ := pxapi.NewClient(pm_api_url, nil, tlsconf)
-	err := client.Login(pm_user, pm_password, pm_otp)
+	// The pxapi client shares the http.Client of the session, which
+	// authenticates its requests with the ticket or API token set above.
+	client, _ := pxapi.NewClient(pm_api_url, session.httpClient, tlsconf)
+	return client, session, nil
+}
+
+// nextVmId reserves the lowest VMID of pm_vmid_range which is not used
+// anywhere in the cluster. Concurrent creates get different VMIDs, until the
+// reservation is dropped by releaseVmId once the create call is done.
+func nextVmId(pconf *providerConfiguration) (int, error) {
+	pconf.VmIdMutex.Lock()
+	defer pconf.VmIdMutex.Unlock()
+
+	used, err := clusterVmIds(pconf)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	return client, nil
+	for vmId := pconf.MinVMID; vmId <= pconf.MaxVMID; vmId++ {
+		if used[vmId] || pconf.ReservedVMIDs[vmId] {
+			continue
+		}
+		// The resource list can lag behind VMs which are being created
+		// right now, e.g. by another Terraform run. Proxmox's own check
+		// also knows about those.
+		free, err := vmIdIsFree(pconf, vmId)
+		if err != nil {
+			return 0, err
+		}
+		if free {
+			pconf.ReservedVMIDs[vmId] = true
+			return vmId, nil
+		}
+	}
+	return 0, fmt.Errorf("No free VMID left in pm_vmid_range %d-%d", pconf.MinVMID, pconf.MaxVMID)
 }
 
-func nextVmId(pconf *providerConfiguration) (nextId int, err error) {
-	pconf.Mutex.Lock()
-	pconf.MaxVMID, err = pconf.Client.GetNextID(pconf.MaxVMID + 1)
+func releaseVmId(pconf *providerConfiguration, vmId int) {
+	pconf.VmIdMutex.Lock()
+	delete(pconf.ReservedVMIDs, vmId)
+	pconf.VmIdMutex.Unlock()
+}
+
+// clusterVmIds returns the VMIDs of all VMs and containers of the cluster.
+func clusterVmIds(pconf *providerConfiguration) (map[int]bool, error) {
+	resp, err := pconf.Session.Get("/cluster/resources", map[string]interface{}{"type": "vm"})
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	nextId = pconf.MaxVMID
-	pconf.Mutex.Unlock()
-	return nextId, nil
+	used := map[int]bool{}
+	vms, _ := resp["data"].([]interface{})
+	for _, vm := range vms {
+		if vmId, ok := vm.(map[string]interface{})["vmid"].(float64); ok {
+			used[int(vmId)] = true
+		}
+	}
+	return used, nil
+}
+
+func vmIdIsFree(pconf *providerConfiguration, vmId int) (bool, error) {
+	_, err := pconf.Session.Get("/cluster/nextid", map[string]interface{}{"vmid": vmId})
+	if apiErr, ok := err.(*apiError); ok && apiErr.StatusCode == http.StatusBadRequest {
+		// Proxmox answers "VM <vmid> already exists".
+		return false, nil
+	}
+	return err == nil, err
 }
 
 func pmParallelBegin(pconf *providerConfiguration) {
@@ -0,0 +1,202 @@
+package proxmox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newVmIdServer fakes a cluster with the used VMIDs, and the ones Proxmox
+// knows are being created, which /cluster/resources does not list yet.
+func newVmIdServer(used []int, creating []int) *fakeApiServer {
+	server := newFakeApiServer()
+	server.handle("GET /cluster/resources", func(w http.ResponseWriter, r *http.Request) {
+		var vms []interface{}
+		for _, vmId := range used {
+			vms = append(vms, map[string]interface{}{"vmid": vmId, "type": "qemu"})
+		}
+		writeApiData(w, vms)
+	})
+	server.handle("GET /cluster/nextid", func(w http.ResponseWriter, r *http.Request) {
+		vmId := r.URL.Query().Get("vmid")
+		for _, taken := range append(used, creating...) {
+			if vmId == fmt.Sprint(taken) {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"errors": map[string]interface{}{"vmid": "VM " + vmId + " already exists"},
+				})
+				return
+			}
+		}
+		writeApiData(w, vmId)
+	})
+	return server
+}
+
+func newVmIdConfig(server *fakeApiServer, minVmId int, maxVmId int) *providerConfiguration {
+	pconf := server.config()
+	pconf.MinVMID = minVmId
+	pconf.MaxVMID = maxVmId
+	pconf.ReservedVMIDs = map[int]bool{}
+	pconf.VmIdMutex = &sync.Mutex{}
+	return pconf
+}
+
+func TestNextVmId(t *testing.T) {
+	tests := []struct {
+		name     string
+		used     []int
+		creating []int
+		reserved []int
+		expected int
+		valid    bool
+	}{
+		{"lowest of the range", []int{99, 201}, nil, nil, 100, true},
+		{"used", []int{100, 101}, nil, nil, 102, true},
+		{"being created", []int{100}, []int{101}, nil, 102, true},
+		{"reserved", nil, nil, []int{100, 102}, 101, true},
+		{"highest of the range", []int{100, 101, 102, 103}, []int{104}, nil, 105, true},
+		{"range full", []int{100, 101, 102}, []int{103, 104}, []int{105}, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newVmIdServer(test.used, test.creating)
+			defer server.Close()
+			pconf := newVmIdConfig(server, 100, 105)
+			for _, vmId := range test.reserved {
+				pconf.ReservedVMIDs[vmId] = true
+			}
+
+			vmId, err := nextVmId(pconf)
+			if !test.valid {
+				if err == nil {
+					t.Fatalf("expected an error, got VMID %d", vmId)
+				}
+				if len(pconf.ReservedVMIDs) != len(test.reserved) {
+					t.Errorf("expected no new VMID to be reserved, got %v", pconf.ReservedVMIDs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if vmId != test.expected {
+				t.Errorf("expected VMID %d, got %d", test.expected, vmId)
+			}
+			if !pconf.ReservedVMIDs[vmId] {
+				t.Errorf("expected VMID %d to be reserved", vmId)
+			}
+		})
+	}
+}
+
+func TestNextVmIdReleasesLockOnError(t *testing.T) {
+	tests := map[string]string{
+		"resource list fails": "GET /cluster/resources",
+		"VMID check fails":    "GET /cluster/nextid",
+	}
+	for name, failing := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := newVmIdServer(nil, nil)
+			defer server.Close()
+			server.handle(failing, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+			})
+			pconf := newVmIdConfig(server, 100, 105)
+
+			if _, err := nextVmId(pconf); err == nil {
+				t.Fatal("expected an error")
+			}
+			if len(pconf.ReservedVMIDs) != 0 {
+				t.Errorf("expected no VMID to be reserved, got %v", pconf.ReservedVMIDs)
+			}
+			locked := make(chan struct{})
+			go func() {
+				pconf.VmIdMutex.Lock()
+				pconf.VmIdMutex.Unlock()
+				close(locked)
+			}()
+			select {
+			case <-locked:
+			case <-time.After(5 * time.Second):
+				t.Fatal("expected nextVmId to release the lock on errors")
+			}
+		})
+	}
+}
+
+func TestReleaseVmId(t *testing.T) {
+	server := newVmIdServer(nil, nil)
+	defer server.Close()
+	pconf := newVmIdConfig(server, 100, 105)
+
+	first, _ := nextVmId(pconf)
+	second, _ := nextVmId(pconf)
+	if first != 100 || second != 101 {
+		t.Fatalf("expected VMIDs 100 and 101, got %d and %d", first, second)
+	}
+	// A VMID whose create failed can be handed out again.
+	releaseVmId(pconf, first)
+	if vmId, _ := nextVmId(pconf); vmId != first {
+		t.Errorf("expected the released VMID %d, got %d", first, vmId)
+	}
+}
+
+func TestNextVmIdConcurrent(t *testing.T) {
+	server := newVmIdServer([]int{100, 105}, nil)
+	defer server.Close()
+	pconf := newVmIdConfig(server, 100, 199)
+
+	const creates = 20
+	vmIds := make(chan int, creates)
+	var wg sync.WaitGroup
+	for i := 0; i < creates; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vmId, err := nextVmId(pconf)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			vmIds <- vmId
+		}()
+	}
+	wg.Wait()
+	close(vmIds)
+
+	seen := map[int]bool{}
+	for vmId := range vmIds {
+		if seen[vmId] || vmId == 100 || vmId == 105 {
+			t.Errorf("VMID %d was handed out twice or is in use", vmId)
+		}
+		seen[vmId] = true
+	}
+	if len(seen) != creates {
+		t.Errorf("expected %d VMIDs, got %d", creates, len(seen))
+	}
+}
+
+func TestVmIdIsFree(t *testing.T) {
+	server := newVmIdServer([]int{100}, nil)
+	defer server.Close()
+	pconf := newVmIdConfig(server, 100, 105)
+
+	if free, err := vmIdIsFree(pconf, 100); free || err != nil {
+		t.Errorf("expected VMID 100 to be taken, got %v, %v", free, err)
+	}
+	if free, err := vmIdIsFree(pconf, 101); !free || err != nil {
+		t.Errorf("expected VMID 101 to be free, got %v, %v", free, err)
+	}
+
+	// Other errors are not mistaken for a taken VMID.
+	server.handle("GET /cluster/nextid", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	if _, err := vmIdIsFree(pconf, 101); err == nil {
+		t.Error("expected the error of the VMID check")
+	}
+}
@@ -1,16 +1,19 @@
 package proxmox
 
 import (
+	"fmt"
 	"strings"
 
 	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
 	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
 )
 
 var resourceQemuSchema = map[string]*schema.Schema{
 	"name": &schema.Schema{
-		Type:     schema.TypeString,
-		Required: true,
+		Type:         schema.TypeString,
+		Required:     true,
+		ValidateFunc: validateDnsName,
 	},
 	"desc": &schema.Schema{
 		Type:     schema.TypeString,
@@ -23,10 +26,18 @@ var resourceQemuSchema = map[string]*schema.Schema{
 		Type:     schema.TypeString,
 		Required: true,
 	},
+	"vmid": &schema.Schema{
+		Type:         schema.TypeInt,
+		Optional:     true,
+		Computed:     true,
+		ForceNew:     true,
+		ValidateFunc: validateVmId,
+	},
 	"bios": &schema.Schema{
-		Type:     schema.TypeString,
-		Optional: true,
-		Default:  "seabios",
+		Type:         schema.TypeString,
+		Optional:     true,
+		Default:      "seabios",
+		ValidateFunc: validation.StringInSlice([]string{"seabios", "ovmf"}, false),
 	},
 	"onboot": &schema.Schema{
 		Type:     schema.TypeBool,
@@ -34,19 +45,22 @@ var resourceQemuSchema = map[string]*schema.Schema{
 		Default:  true,
 	},
 	"boot": &schema.Schema{
-		Type:     schema.TypeString,
-		Optional: true,
-		Default:  "cdn",
+		Type:         schema.TypeString,
+		Optional:     true,
+		Default:      "cdn",
+		ValidateFunc: validation.StringMatch(rxBootOrder, "must be up to four of a, c, d and n, or order=<devices>"),
 	},
 	"bootdisk": &schema.Schema{
-		Type:     schema.TypeString,
-		Optional: true,
-		Computed: true,
+		Type:         schema.TypeString,
+		Optional:     true,
+		Computed:     true,
+		ValidateFunc: validation.StringMatch(rxBootDisk, "must be a disk like virtio0"),
 	},
 	"agent": &schema.Schema{
-		Type:     schema.TypeInt,
-		Optional: true,
-		Default:  0,
+		Type:         schema.TypeInt,
+		Optional:     true,
+		Default:      0,
+		ValidateFunc: validation.IntInSlice([]int{0, 1}),
 	},
 	"iso": &schema.Schema{
 		Type:     schema.TypeString,
@@ -54,9 +68,17 @@ var resourceQemuSchema = map[string]*schema.Schema{
 		ForceNew: true,
 	},
 	"clone": &schema.Schema{
-		Type:     schema.TypeString,
-		Optional: true,
-		ForceNew: true,
+		Type:          schema.TypeString,
+		Optional:      true,
+		ForceNew:      true,
+		ConflictsWith: []string{"clone_vmid"},
+	},
+	"clone_vmid": &schema.Schema{
+		Type:          schema.TypeInt,
+		Optional:      true,
+		ForceNew:      true,
+		ConflictsWith: []string{"clone"},
+		ValidateFunc:  validateVmId,
 	},
 	"full_clone": &schema.Schema{
 		Type:     schema.TypeBool,
@@ -65,8 +87,9 @@ var resourceQemuSchema = map[string]*schema.Schema{
 		Default:  true,
 	},
 	"hastate": &schema.Schema{
-		Type:     schema.TypeString,
-		Optional: true,
+		Type:         schema.TypeString,
+		Optional:     true,
+		ValidateFunc: validation.StringInSlice([]string{"started", "stopped", "enabled", "disabled", "ignored"}, false),
 	},
 	"qemu_os": &schema.Schema{
 		Type:     schema.TypeString,
@@ -74,40 +97,47 @@ var resourceQemuSchema = map[string]*schema.Schema{
 		Default:  "l26",
 		DiffSuppressFunc: func(k, old, new string, d *schema.ResourceData) bool {
 			if new == "l26" {
-				return len(d.Get("clone").(string)) > 0 // the cloned source may have a different os, which we shoud leave alone
+				return len(d.Get("clone").(string)) > 0 || d.Get("clone_vmid").(int) != 0 // the cloned source may have a different os, which we shoud leave alone
 			}
 			return strings.TrimSpace(old) == strings.TrimSpace(new)
 		},
+		ValidateFunc: validation.StringInSlice([]string{"other", "wxp", "w2k", "w2k3", "w2k8", "wvista", "win7", "win8", "win10", "win11", "l24", "l26", "solaris"}, false),
 	},
 	"memory": &schema.Schema{
-		Type:     schema.TypeInt,
-		Optional: true,
-		Default:  512,
+		Type:         schema.TypeInt,
+		Optional:     true,
+		Default:      512,
+		ValidateFunc: validation.IntAtLeast(16),
 	},
 	"balloon": &schema.Schema{
-		Type:     schema.TypeInt,
-		Optional: true,
-		Default:  0,
+		Type:         schema.TypeInt,
+		Optional:     true,
+		Default:      0,
+		ValidateFunc: validation.IntAtLeast(0),
 	},
 	"cores": &schema.Schema{
-		Type:     schema.TypeInt,
-		Optional: true,
-		Default:  1,
+		Type:         schema.TypeInt,
+		Optional:     true,
+		Default:      1,
+		ValidateFunc: validation.IntAtLeast(1),
 	},
 	"sockets": &schema.Schema{
-		Type:     schema.TypeInt,
-		Optional: true,
-		Default:  1,
+		Type:         schema.TypeInt,
+		Optional:     true,
+		Default:      1,
+		ValidateFunc: validation.IntAtLeast(1),
 	},
 	"vcpus": &schema.Schema{
-		Type:     schema.TypeInt,
-		Optional: true,
-		Default:  0,
+		Type:         schema.TypeInt,
+		Optional:     true,
+		Default:      0,
+		ValidateFunc: validation.IntAtLeast(0),
 	},
 	"cpu": &schema.Schema{
-		Type:     schema.TypeString,
-		Optional: true,
-		Default:  "host",
+		Type:         schema.TypeString,
+		Optional:     true,
+		Default:      "host",
+		ValidateFunc: validation.StringMatch(rxCpuType, "must be a CPU type like host or kvm64"),
 	},
 	"numa": &schema.Schema{
 		Type:     schema.TypeBool,
@@ -115,14 +145,16 @@ var resourceQemuSchema = map[string]*schema.Schema{
 		Default:  false,
 	},
 	"hotplug": &schema.Schema{
-		Type:     schema.TypeString,
-		Optional: true,
-		Default:  "network,disk,usb",
+		Type:         schema.TypeString,
+		Optional:     true,
+		Default:      "network,disk,usb",
+		ValidateFunc: validateHotplug,
 	},
 	"scsihw": &schema.Schema{
-		Type:     schema.TypeString,
-		Optional: true,
-		Computed: true,
+		Type:         schema.TypeString,
+		Optional:     true,
+		Computed:     true,
+		ValidateFunc: validation.StringInSlice([]string{"lsi", "lsi53c810", "virtio-scsi-pci", "virtio-scsi-single", "megasas", "pvscsi"}, false),
 	},
 	"vga": &schema.Schema{
 		Type:     schema.TypeSet,
@@ -130,13 +162,15 @@ var resourceQemuSchema = map[string]*schema.Schema{
 		Elem: &schema.Resource{
 			Schema: map[string]*schema.Schema{
 				"type": {
-					Type:     schema.TypeString,
-					Optional: true,
-					Default:  "std",
+					Type:         schema.TypeString,
+					Optional:     true,
+					Default:      "std",
+					ValidateFunc: validation.StringInSlice([]string{"std", "cirrus", "vmware", "qxl", "qxl2", "qxl3", "qxl4", "serial0", "serial1", "serial2", "serial3", "virtio", "none"}, false),
 				},
 				"memory": {
-					Type:     schema.TypeInt,
-					Optional: true,
+					Type:         schema.TypeInt,
+					Optional:     true,
+					ValidateFunc: validation.IntBetween(4, 512),
 				},
 			},
 		},
@@ -147,27 +181,33 @@ var resourceQemuSchema = map[string]*schema.Schema{
 		Elem: &schema.Resource{
 			Schema: map[string]*schema.Schema{
 				"id": &schema.Schema{
-					Type:     schema.TypeInt,
-					Required: true,
+					Type:         schema.TypeInt,
+					Required:     true,
+					ValidateFunc: validation.IntBetween(0, 31),
 				},
 				"model": &schema.Schema{
-					Type:     schema.TypeString,
-					Required: true,
+					Type:         schema.TypeString,
+					Required:     true,
+					ValidateFunc: validation.StringInSlice([]string{"virtio", "e1000", "e1000-82540em", "e1000-82544gc", "e1000-82545em", "i82551", "i82557b", "i82559er", "ne2k_isa", "ne2k_pci", "pcnet", "rtl8139", "vmxnet3"}, false),
 				},
 				"macaddr": &schema.Schema{
-					Type:     schema.TypeString,
-					Computed: true,
+					Type:         schema.TypeString,
+					Optional:     true,
+					Computed:     true,
+					ValidateFunc: validation.IsMACAddress,
 				},
 				"bridge": &schema.Schema{
-					Type:     schema.TypeString,
-					Optional: true,
-					Default:  "nat",
+					Type:         schema.TypeString,
+					Optional:     true,
+					Default:      "nat",
+					ValidateFunc: validateBridge,
 				},
 				"tag": &schema.Schema{
-					Type:        schema.TypeInt,
-					Optional:    true,
-					Description: "VLAN tag.",
-					Default:     -1,
+					Type:         schema.TypeInt,
+					Optional:     true,
+					Description:  "VLAN tag.",
+					Default:      -1,
+					ValidateFunc: validateVlanTag,
 				},
 				"firewall": &schema.Schema{
 					Type:     schema.TypeBool,
@@ -175,14 +215,16 @@ var resourceQemuSchema = map[string]*schema.Schema{
 					Default:  false,
 				},
 				"rate": &schema.Schema{
-					Type:     schema.TypeInt,
-					Optional: true,
-					Default:  -1,
+					Type:         schema.TypeInt,
+					Optional:     true,
+					Default:      -1,
+					ValidateFunc: validation.IntAtLeast(-1),
 				},
 				"queues": &schema.Schema{
-					Type:     schema.TypeInt,
-					Optional: true,
-					Default:  -1,
+					Type:         schema.TypeInt,
+					Optional:     true,
+					Default:      -1,
+					ValidateFunc: validation.Any(validation.IntInSlice([]int{-1}), validation.IntBetween(0, 64)),
 				},
 				"link_down": &schema.Schema{
 					Type:     schema.TypeBool,
@@ -198,36 +240,42 @@ var resourceQemuSchema = map[string]*schema.Schema{
 		Elem: &schema.Resource{
 			Schema: map[string]*schema.Schema{
 				"id": &schema.Schema{
-					Type:     schema.TypeInt,
-					Required: true,
+					Type:         schema.TypeInt,
+					Required:     true,
+					ValidateFunc: validation.IntBetween(0, 30),
 				},
 				"type": &schema.Schema{
-					Type:     schema.TypeString,
-					Required: true,
+					Type:         schema.TypeString,
+					Required:     true,
+					ValidateFunc: validation.StringInSlice([]string{"ide", "sata", "scsi", "virtio"}, false),
 				},
 				"storage": &schema.Schema{
 					Type:     schema.TypeString,
 					Required: true,
 				},
 				"storage_type": &schema.Schema{
-					Type:        schema.TypeString,
-					Optional:    true,
-					Default:     "dir",
-					Description: "One of PVE types as described: https://pve.proxmox.com/wiki/Storage",
+					Type:         schema.TypeString,
+					Optional:     true,
+					Default:      "dir",
+					Description:  "One of PVE types as described: https://pve.proxmox.com/wiki/Storage",
+					ValidateFunc: validation.StringInSlice([]string{"dir", "nfs", "cifs", "glusterfs", "cephfs", "lvm", "lvmthin", "zfspool", "zfs", "iscsi", "iscsidirect", "rbd", "drbd"}, false),
 				},
 				"size": &schema.Schema{
-					Type:     schema.TypeString,
-					Required: true,
+					Type:         schema.TypeString,
+					Required:     true,
+					ValidateFunc: validateDiskSize,
 				},
 				"format": &schema.Schema{
-					Type:     schema.TypeString,
-					Optional: true,
-					Default:  "raw",
+					Type:         schema.TypeString,
+					Optional:     true,
+					Default:      "raw",
+					ValidateFunc: validation.StringInSlice([]string{"raw", "qcow2", "vmdk", "qed", "qcow", "cow", "cloop"}, false),
 				},
 				"cache": &schema.Schema{
-					Type:     schema.TypeString,
-					Optional: true,
-					Default:  "none",
+					Type:         schema.TypeString,
+					Optional:     true,
+					Default:      "none",
+					ValidateFunc: validation.StringInSlice([]string{"none", "writethrough", "writeback", "unsafe", "directsync"}, false),
 				},
 				"backup": &schema.Schema{
 					Type:     schema.TypeBool,
@@ -245,29 +293,34 @@ var resourceQemuSchema = map[string]*schema.Schema{
 					Default:  false,
 				},
 				"mbps": &schema.Schema{
-					Type:     schema.TypeInt,
-					Optional: true,
-					Default:  0,
+					Type:         schema.TypeInt,
+					Optional:     true,
+					Default:      0,
+					ValidateFunc: validation.IntAtLeast(0),
 				},
 				"mbps_rd": &schema.Schema{
-					Type:     schema.TypeInt,
-					Optional: true,
-					Default:  0,
+					Type:         schema.TypeInt,
+					Optional:     true,
+					Default:      0,
+					ValidateFunc: validation.IntAtLeast(0),
 				},
 				"mbps_rd_max": &schema.Schema{
-					Type:     schema.TypeInt,
-					Optional: true,
-					Default:  0,
+					Type:         schema.TypeInt,
+					Optional:     true,
+					Default:      0,
+					ValidateFunc: validation.IntAtLeast(0),
 				},
 				"mbps_wr": &schema.Schema{
-					Type:     schema.TypeInt,
-					Optional: true,
-					Default:  0,
+					Type:         schema.TypeInt,
+					Optional:     true,
+					Default:      0,
+					ValidateFunc: validation.IntAtLeast(0),
 				},
 				"mbps_wr_max": &schema.Schema{
-					Type:     schema.TypeInt,
-					Optional: true,
-					Default:  0,
+					Type:         schema.TypeInt,
+					Optional:     true,
+					Default:      0,
+					ValidateFunc: validation.IntAtLeast(0),
 				},
 			},
 		},
@@ -278,19 +331,22 @@ var resourceQemuSchema = map[string]*schema.Schema{
 		Elem: &schema.Resource{
 			Schema: map[string]*schema.Schema{
 				"id": &schema.Schema{
-					Type:     schema.TypeInt,
-					Required: true,
+					Type:         schema.TypeInt,
+					Required:     true,
+					ValidateFunc: validation.IntBetween(0, 3),
 				},
 				"type": &schema.Schema{
-					Type:     schema.TypeString,
-					Required: true,
+					Type:         schema.TypeString,
+					Required:     true,
+					ValidateFunc: validation.StringMatch(rxSerialType, "must be socket or a device path like /dev/ttyS0"),
 				},
 			},
 		},
 	},
 	"os_type": &schema.Schema{
-		Type:     schema.TypeString,
-		Optional: true,
+		Type:         schema.TypeString,
+		Optional:     true,
+		ValidateFunc: validation.StringInSlice([]string{"ubuntu", "centos", "cloud-init"}, false),
 	},
 	"os_network_config": &schema.Schema{
 		Type:     schema.TypeString,
@@ -300,15 +356,91 @@ var resourceQemuSchema = map[string]*schema.Schema{
 			return strings.TrimSpace(old) == strings.TrimSpace(new)
 		},
 	},
+	"preprovision": &schema.Schema{
+		Type:     schema.TypeBool,
+		Optional: true,
+		Default:  true,
+	},
+	"ssh_forward_ip": &schema.Schema{
+		Type:     schema.TypeString,
+		Optional: true,
+	},
+	"ssh_user": &schema.Schema{
+		Type:     schema.TypeString,
+		Optional: true,
+	},
+	"ssh_private_key": &schema.Schema{
+		Type:      schema.TypeString,
+		Optional:  true,
+		Sensitive: true,
+		DiffSuppressFunc: func(k, old, new string, d *schema.ResourceData) bool {
+			return strings.TrimSpace(old) == strings.TrimSpace(new)
+		},
+	},
+	"ssh_host": &schema.Schema{
+		Type:     schema.TypeString,
+		Computed: true,
+	},
+	"ssh_port": &schema.Schema{
+		Type:     schema.TypeString,
+		Computed: true,
+	},
+	"default_ipv4_address": &schema.Schema{
+		Type:     schema.TypeString,
+		Computed: true,
+	},
+	"default_ipv6_address": &schema.Schema{
+		Type:     schema.TypeString,
+		Computed: true,
+	},
+	"network_interfaces": &schema.Schema{
+		Type:     schema.TypeList,
+		Computed: true,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"name": &schema.Schema{
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"mac_address": &schema.Schema{
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"ip_addresses": &schema.Schema{
+					Type:     schema.TypeList,
+					Computed: true,
+					Elem:     &schema.Schema{Type: schema.TypeString},
+				},
+			},
+		},
+	},
+	"ci_wait": &schema.Schema{
+		Type:         schema.TypeInt,
+		Optional:     true,
+		Default:      30,
+		ValidateFunc: validation.IntAtLeast(0),
+	},
+	"shutdown_timeout": &schema.Schema{
+		Type:         schema.TypeInt,
+		Optional:     true,
+		Default:      defaultShutdownTimeout,
+		ValidateFunc: validation.IntAtLeast(0),
+	},
+	"force_stop": &schema.Schema{
+		Type:     schema.TypeBool,
+		Optional: true,
+		Default:  true,
+	},
 	"force_create": &schema.Schema{
 		Type:     schema.TypeBool,
 		Optional: true,
 		Default:  false,
 	},
 	"clone_wait": &schema.Schema{
-		Type:     schema.TypeInt,
-		Optional: true,
-		Default:  15,
+		Type:       schema.TypeInt,
+		Optional:   true,
+		Default:    15,
+		Deprecated: "The clone task is now tracked until it finishes, clone_wait has no effect anymore",
 	},
 	"ciuser": &schema.Schema{
 		Type:     schema.TypeString,
@@ -327,8 +459,9 @@ var resourceQemuSchema = map[string]*schema.Schema{
 		Optional: true,
 	},
 	"nameserver": &schema.Schema{
-		Type:     schema.TypeString,
-		Optional: true,
+		Type:         schema.TypeString,
+		Optional:     true,
+		ValidateFunc: validateNameservers,
 	},
 	"sshkeys": &schema.Schema{
 		Type:     schema.TypeString,
@@ -338,26 +471,82 @@ var resourceQemuSchema = map[string]*schema.Schema{
 		},
 	},
 	"ipconfig0": &schema.Schema{
-		Type:     schema.TypeString,
-		Optional: true,
+		Type:          schema.TypeString,
+		Optional:      true,
+		Deprecated:    "Use an ipconfig block with id = 0 instead",
+		ConflictsWith: []string{"ipconfig"},
 	},
 	"ipconfig1": &schema.Schema{
-		Type:     schema.TypeString,
-		Optional: true,
+		Type:          schema.TypeString,
+		Optional:      true,
+		Deprecated:    "Use an ipconfig block with id = 1 instead",
+		ConflictsWith: []string{"ipconfig"},
 	},
 	"ipconfig2": &schema.Schema{
-		Type:     schema.TypeString,
+		Type:          schema.TypeString,
+		Optional:      true,
+		Deprecated:    "Use an ipconfig block with id = 2 instead",
+		ConflictsWith: []string{"ipconfig"},
+	},
+	"ipconfig": &schema.Schema{
+		Type:     schema.TypeSet,
 		Optional: true,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"id": &schema.Schema{
+					Type:         schema.TypeInt,
+					Required:     true,
+					ValidateFunc: validation.IntBetween(0, 31),
+				},
+				"ipv4": &schema.Schema{
+					Type:         schema.TypeString,
+					Optional:     true,
+					ValidateFunc: validation.IsCIDR,
+				},
+				"gw": &schema.Schema{
+					Type:         schema.TypeString,
+					Optional:     true,
+					ValidateFunc: validation.IsIPv4Address,
+				},
+				"ipv6": &schema.Schema{
+					Type:         schema.TypeString,
+					Optional:     true,
+					ValidateFunc: validateIpOrKeyword("dhcp", "auto"),
+				},
+				"gw6": &schema.Schema{
+					Type:         schema.TypeString,
+					Optional:     true,
+					ValidateFunc: validation.IsIPv6Address,
+				},
+				"dhcp": &schema.Schema{
+					Type:     schema.TypeBool,
+					Optional: true,
+					Default:  false,
+				},
+			},
+		},
 	},
 	"pool": &schema.Schema{
 		Type:     schema.TypeString,
 		Optional: true,
 	},
+	"disk_detach_policy": &schema.Schema{
+		Type:         schema.TypeString,
+		Optional:     true,
+		Default:      "unused",
+		ValidateFunc: validation.StringInSlice([]string{"unused", "destroy"}, false),
+	},
+	"allow_disk_recreate": &schema.Schema{
+		Type:     schema.TypeBool,
+		Optional: true,
+		Default:  false,
+	},
 }
 
 func flattenVmQemu(vmr *pxapi.VmRef, config *pxapi.ConfigQemu, d *schema.ResourceData) {
 	d.SetId(resourceId(vmr.Node(), "qemu", vmr.VmId()))
 	d.Set("target_node", vmr.Node())
+	d.Set("vmid", vmr.VmId())
 	d.Set("name", config.Name)
 	d.Set("desc", config.Description)
 	d.Set("pool", config.Pool)
@@ -386,12 +575,16 @@ func flattenVmQemu(vmr *pxapi.VmRef, config *pxapi.ConfigQemu, d *schema.Resourc
 	d.Set("searchdomain", config.Searchdomain)
 	d.Set("nameserver", config.Nameserver)
 	d.Set("sshkeys", config.Sshkeys)
-	d.Set("ipconfig0", config.Ipconfig0)
-	d.Set("ipconfig1", config.Ipconfig1)
-	d.Set("ipconfig2", config.Ipconfig2)
+	// The ipconfig blocks replace these, see flattenIpconfigs.
+	if usesDeprecatedIpconfigs(d) {
+		d.Set("ipconfig0", config.Ipconfig0)
+		d.Set("ipconfig1", config.Ipconfig1)
+		d.Set("ipconfig2", config.Ipconfig2)
+	}
 
 	// Disks.
 	configDisksSet := d.Get("disk").(*schema.Set)
+	flattenDiskSizes(config.QemuDisks, expandDevices(configDisksSet))
 	activeDisksSet := flattenDevices(configDisksSet, config.QemuDisks)
 	d.Set("disk", activeDisksSet)
 
@@ -412,6 +605,34 @@ func flattenVmQemu(vmr *pxapi.VmRef, config *pxapi.ConfigQemu, d *schema.Resourc
 	d.Set("serial", activeSerialSet)
 }
 
+// maxDiskIds are the highest disk id of each bus, the id schema only checks
+// the highest of all.
+var maxDiskIds = map[string]int{
+	"ide":    3,
+	"sata":   5,
+	"scsi":   30,
+	"virtio": 15,
+}
+
+// validateDiskIds rejects disks with an id beyond the slots of their bus.
+func validateDiskIds(disks *schema.Set) error {
+	for _, raw := range disks.List() {
+		disk, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		diskType, _ := disk["type"].(string)
+		maxId, ok := maxDiskIds[diskType]
+		if !ok {
+			continue
+		}
+		if id, _ := disk["id"].(int); id > maxId {
+			return fmt.Errorf("Disk %d: %s disks have ids between 0 and %d", id, diskType, maxId)
+		}
+	}
+	return nil
+}
+
 // Converting from schema.TypeSet to map of id and conf for each device,
 // which will be sent to Proxmox API.
 func expandDevices(devicesSet *schema.Set) pxapi.QemuDevices {
@@ -485,7 +706,6 @@ func expandVmQemu(d *schema.ResourceData) pxapi.ConfigQemu {
 		QemuNuma:    d.Get("numa").(bool),
 		Hotplug:     d.Get("hotplug").(string),
 		Scsihw:      d.Get("scsihw").(string),
-		HaState:     d.Get("hastate").(string),
 		QemuOs:      d.Get("qemu_os").(string),
 		// Cloud-init.
 		CIuser:       d.Get("ciuser").(string),
@@ -499,10 +719,15 @@ func expandVmQemu(d *schema.ResourceData) pxapi.ConfigQemu {
 		Ipconfig2:    d.Get("ipconfig2").(string),
 
 		QemuNetworks: expandDevices(d.Get("network").(*schema.Set)),
-		QemuDisks:    expandDevices(d.Get("disk").(*schema.Set)),
+		QemuDisks:    expandDiskSizes(expandDevices(d.Get("disk").(*schema.Set))),
 		QemuSerials:  expandDevices(d.Get("serial").(*schema.Set)),
 	}
 
+	// Templates have no hastate argument.
+	if haState, ok := d.GetOk("hastate"); ok {
+		config.HaState = haState.(string)
+	}
+
 	vga := d.Get("vga").(*schema.Set)
 	qemuVgaList := vga.List()
 
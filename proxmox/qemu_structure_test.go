@@ -0,0 +1,50 @@
+package proxmox
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+)
+
+func TestValidateDiskIds(t *testing.T) {
+	tests := []struct {
+		diskType string
+		id       int
+		valid    bool
+	}{
+		{"ide", 3, true},
+		{"ide", 4, false},
+		{"sata", 5, true},
+		{"sata", 6, false},
+		{"scsi", 30, true},
+		{"virtio", 15, true},
+		{"virtio", 16, false},
+	}
+	for _, test := range tests {
+		config := terraform.NewResourceConfigRaw(map[string]interface{}{
+			"name":        "web-1",
+			"target_node": "pve",
+			"disk": []interface{}{map[string]interface{}{
+				"id":      test.id,
+				"type":    test.diskType,
+				"storage": "local-lvm",
+				"size":    "10G",
+			}},
+		})
+		// Both resources check the ids when planning.
+		for name, resource := range map[string]*schema.Resource{
+			"proxmox_vm_qemu":          resourceVmQemu(),
+			"proxmox_vm_qemu_template": resourceVmQemuTemplate(),
+		} {
+			_, err := resource.Diff(nil, config, nil)
+			if test.valid && err != nil {
+				t.Errorf("%s: %s disk %d: unexpected error: %v", name, test.diskType, test.id, err)
+			}
+			if !test.valid && (err == nil || !strings.Contains(err.Error(), "have ids between 0 and")) {
+				t.Errorf("%s: %s disk %d: expected an id error, got %v", name, test.diskType, test.id, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package proxmox
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
+)
+
+var rxAclPath = regexp.MustCompile(`^/[^|]*$`)
+
+// The arguments naming who an ACL entry is for, and the type and parameter
+// Proxmox uses for them.
+var aclSubjects = []struct {
+	argument string
+	aclType  string
+	param    string
+}{
+	{"user", "user", "users"},
+	{"group", "group", "groups"},
+	{"token", "token", "tokens"},
+}
+
+func resourceAcl() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceAclCreate,
+		Read:   resourceAclRead,
+		Update: resourceAclUpdate,
+		Delete: resourceAclDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"path": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				ValidateFunc: validation.StringMatch(rxAclPath, "must be an absolute path, like /vms/100"),
+			},
+			"role": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				ValidateFunc: validateAccessId,
+			},
+			"user": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				ExactlyOneOf: []string{"user", "group", "token"},
+				ValidateFunc: validateUserId,
+			},
+			"group": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				ExactlyOneOf: []string{"user", "group", "token"},
+				ValidateFunc: validateAccessId,
+			},
+			"token": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				ExactlyOneOf: []string{"user", "group", "token"},
+				ValidateFunc: validation.StringMatch(rxTokenId, "must be a token id, like terraform@pve!ci"),
+			},
+			"propagate": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+		},
+	}
+}
+
+func resourceAclCreate(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	aclType, ugid := aclSubject(d)
+	err := putAcl(pconf, d, false)
+	if err != nil {
+		return err
+	}
+	d.SetId(aclId(d.Get("path").(string), aclType, ugid, d.Get("role").(string)))
+	return resourceAclRead(d, meta)
+}
+
+func resourceAclRead(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	path, aclType, ugid, role, err := parseAclId(d.Id())
+	if err != nil {
+		return err
+	}
+	resp, err := pconf.Session.Get("/access/acl", nil)
+	if err != nil {
+		return err
+	}
+	var entry map[string]interface{}
+	entries, _ := resp["data"].([]interface{})
+	for _, candidate := range entries {
+		candidate, _ := candidate.(map[string]interface{})
+		if candidate["path"] == path && candidate["type"] == aclType && candidate["ugid"] == ugid && candidate["roleid"] == role {
+			entry = candidate
+			break
+		}
+	}
+	if entry == nil {
+		log.Printf("[WARN] ACL entry %s is gone, removing it from state", d.Id())
+		d.SetId("")
+		return nil
+	}
+
+	d.Set("path", path)
+	d.Set("role", role)
+	for _, subject := range aclSubjects {
+		if subject.aclType == aclType {
+			d.Set(subject.argument, ugid)
+		} else {
+			d.Set(subject.argument, "")
+		}
+	}
+	d.Set("propagate", apiInt(entry["propagate"]) == 1)
+	return nil
+}
+
+func resourceAclUpdate(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	// Setting the entry again replaces its propagate flag.
+	err := putAcl(pconf, d, false)
+	if err != nil {
+		return err
+	}
+	return resourceAclRead(d, meta)
+}
+
+func resourceAclDelete(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	return putAcl(pconf, d, true)
+}
+
+// putAcl sets or, with remove, deletes the ACL entry.
+func putAcl(pconf *providerConfiguration, d *schema.ResourceData, remove bool) error {
+	aclType, ugid := aclSubject(d)
+	params := map[string]interface{}{
+		"path":      d.Get("path").(string),
+		"roles":     d.Get("role").(string),
+		"propagate": d.Get("propagate").(bool),
+	}
+	for _, subject := range aclSubjects {
+		if subject.aclType == aclType {
+			params[subject.param] = ugid
+		}
+	}
+	if remove {
+		params["delete"] = true
+	}
+	return pconf.Retry.Do(func() error {
+		_, err := pconf.Session.Put("/access/acl", params)
+		return err
+	})
+}
+
+// aclSubject returns the type and id of who the entry is for.
+func aclSubject(d *schema.ResourceData) (string, string) {
+	for _, subject := range aclSubjects {
+		if ugid := d.Get(subject.argument).(string); ugid != "" {
+			return subject.aclType, ugid
+		}
+	}
+	return "", ""
+}
+
+// ACL ids are separated by |, which none of the parts can contain.
+func aclId(path string, aclType string, ugid string, role string) string {
+	return strings.Join([]string{path, aclType, ugid, role}, "|")
+}
+
+func parseAclId(id string) (path string, aclType string, ugid string, role string, err error) {
+	parts := strings.Split(id, "|")
+	if len(parts) != 4 {
+		return "", "", "", "", fmt.Errorf("Invalid resource format: %s. Must be path|type|ugid|role, like /vms/100|user|terraform@pve|PVEVMAdmin", id)
+	}
+	switch parts[1] {
+	case "user", "group", "token":
+	default:
+		return "", "", "", "", fmt.Errorf("Invalid resource format: %s. The type must be user, group or token", id)
+	}
+	return parts[0], parts[1], parts[2], parts[3], nil
+}
@@ -0,0 +1,173 @@
+package proxmox
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
+)
+
+func resourceApiToken() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceApiTokenCreate,
+		Read:   resourceApiTokenRead,
+		Update: resourceApiTokenUpdate,
+		Delete: resourceApiTokenDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"userid": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				ValidateFunc: validateUserId,
+			},
+			"tokenid": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				ValidateFunc: validateAccessId,
+			},
+			"comment": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"expire": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"privilege_separation": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+			"full_tokenid": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"secret": {
+				Type:      schema.TypeString,
+				Computed:  true,
+				Sensitive: true,
+			},
+		},
+	}
+}
+
+func resourceApiTokenCreate(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	userID := d.Get("userid").(string)
+	tokenID := d.Get("tokenid").(string)
+	params := apiTokenParams(d)
+	if params["comment"] == "" {
+		delete(params, "comment")
+	}
+	// Not retried, the secret is only returned by the request creating the
+	// token.
+	resp, err := pconf.Session.Post(apiTokenPath(userID, tokenID), params)
+	if err != nil {
+		return err
+	}
+	data, _ := resp["data"].(map[string]interface{})
+	d.SetId(userID + "!" + tokenID)
+	d.Set("secret", apiString(data["value"]))
+	return resourceApiTokenRead(d, meta)
+}
+
+func resourceApiTokenRead(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	userID, tokenID, err := parseApiTokenId(d.Id())
+	if err != nil {
+		return err
+	}
+	// Listing the tokens of a missing user fails, so the user is checked first.
+	user, err := apiListItem(pconf.Session, "/access/users", "userid", userID)
+	if err != nil {
+		return err
+	}
+	var token map[string]interface{}
+	if user != nil {
+		token, err = apiListItem(pconf.Session, fmt.Sprintf("%s/token", userPath(userID)), "tokenid", tokenID)
+		if err != nil {
+			return err
+		}
+	}
+	if token == nil {
+		log.Printf("[WARN] API token %s is gone, removing it from state", d.Id())
+		d.SetId("")
+		return nil
+	}
+
+	d.Set("userid", userID)
+	d.Set("tokenid", tokenID)
+	d.Set("full_tokenid", d.Id())
+	d.Set("comment", apiString(token["comment"]))
+	d.Set("expire", apiInt(token["expire"]))
+	d.Set("privilege_separation", apiInt(token["privsep"]) == 1)
+	return nil
+}
+
+func resourceApiTokenUpdate(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	userID, tokenID, err := parseApiTokenId(d.Id())
+	if err != nil {
+		return err
+	}
+	err = pconf.Retry.Do(func() error {
+		_, err := pconf.Session.Put(apiTokenPath(userID, tokenID), apiTokenParams(d))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return resourceApiTokenRead(d, meta)
+}
+
+func resourceApiTokenDelete(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	userID, tokenID, err := parseApiTokenId(d.Id())
+	if err != nil {
+		return err
+	}
+	user, err := apiListItem(pconf.Session, "/access/users", "userid", userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		log.Printf("[DEBUG] user %s of API token %s is already gone", userID, d.Id())
+		return nil
+	}
+	return pconf.Retry.Do(func() error {
+		_, err := pconf.Session.Delete(apiTokenPath(userID, tokenID), nil)
+		return err
+	})
+}
+
+func apiTokenParams(d *schema.ResourceData) map[string]interface{} {
+	return map[string]interface{}{
+		"comment": d.Get("comment").(string),
+		"expire":  d.Get("expire").(int),
+		"privsep": d.Get("privilege_separation").(bool),
+	}
+}
+
+func apiTokenPath(userID string, tokenID string) string {
+	return fmt.Sprintf("%s/token/%s", userPath(userID), url.PathEscape(tokenID))
+}
+
+// parseApiTokenId splits a full token id like terraform@pve!ci into the user
+// and the token name.
+func parseApiTokenId(id string) (userID string, tokenID string, err error) {
+	if !rxTokenId.MatchString(id) {
+		return "", "", fmt.Errorf("Invalid resource format: %s. Must be user@realm!token", id)
+	}
+	i := strings.LastIndex(id, "!")
+	return id[:i], id[i+1:], nil
+}
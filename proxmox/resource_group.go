@@ -0,0 +1,111 @@
+package proxmox
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+func resourceGroup() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceGroupCreate,
+		Read:   resourceGroupRead,
+		Update: resourceGroupUpdate,
+		Delete: resourceGroupDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"groupid": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				ValidateFunc: validateAccessId,
+			},
+			"comment": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"members": {
+				Type:     schema.TypeSet,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+		},
+	}
+}
+
+func resourceGroupCreate(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	groupID := d.Get("groupid").(string)
+	params := map[string]interface{}{
+		"groupid": groupID,
+	}
+	if comment := d.Get("comment").(string); comment != "" {
+		params["comment"] = comment
+	}
+	err := pconf.Retry.Do(func() error {
+		_, err := pconf.Session.Post("/access/groups", params)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	d.SetId(groupID)
+	return resourceGroupRead(d, meta)
+}
+
+func resourceGroupRead(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	group, err := apiListItem(pconf.Session, "/access/groups", "groupid", d.Id())
+	if err != nil {
+		return err
+	}
+	if group == nil {
+		log.Printf("[WARN] group %s is gone, removing it from state", d.Id())
+		d.SetId("")
+		return nil
+	}
+	d.Set("groupid", d.Id())
+	d.Set("comment", apiString(group["comment"]))
+	d.Set("members", apiStringList(group["users"]))
+	return nil
+}
+
+func resourceGroupUpdate(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	params := map[string]interface{}{
+		"comment": d.Get("comment").(string),
+	}
+	err := pconf.Retry.Do(func() error {
+		_, err := pconf.Session.Put(groupPath(d.Id()), params)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return resourceGroupRead(d, meta)
+}
+
+func resourceGroupDelete(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	group, err := apiListItem(pconf.Session, "/access/groups", "groupid", d.Id())
+	if err != nil {
+		return err
+	}
+	if group == nil {
+		log.Printf("[DEBUG] group %s is already gone", d.Id())
+		return nil
+	}
+	return pconf.Retry.Do(func() error {
+		_, err := pconf.Session.Delete(groupPath(d.Id()), nil)
+		return err
+	})
+}
+
+func groupPath(groupID string) string {
+	return fmt.Sprintf("/access/groups/%s", url.PathEscape(groupID))
+}
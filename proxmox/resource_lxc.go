@@ -1,20 +1,34 @@
 package proxmox
 
 import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
 	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
 	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
 )
 
 func resourceLxc() *schema.Resource {
 	*pxapi.Debug = true
-	return &schema.Resource{
+	resource := &schema.Resource{
 		Create: resourceLxcCreate,
 		Read:   resourceLxcRead,
 		Update: resourceLxcUpdate,
-		Delete: resourceVmQemuDelete,
+		Delete: resourceLxcDelete,
 		Importer: &schema.ResourceImporter{
 			State: schema.ImportStatePassthrough,
 		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultTimeout),
+			Update: schema.DefaultTimeout(defaultTimeout),
+			Delete: schema.DefaultTimeout(defaultTimeout),
+		},
+		CustomizeDiff: resourceLxcCustomizeDiff,
+		SchemaVersion: 2,
 
 		Schema: map[string]*schema.Schema{
 			"ostemplate": {
@@ -22,18 +36,21 @@ func resourceLxc() *schema.Resource {
 				Optional: true,
 			},
 			"arch": {
-				Type:     schema.TypeString,
-				Optional: true,
-				Default:  "amd64",
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "amd64",
+				ValidateFunc: validation.StringInSlice([]string{"amd64", "i386", "arm64", "armhf"}, false),
 			},
 			"bwlimit": {
-				Type:     schema.TypeInt,
-				Optional: true,
+				Type:         schema.TypeInt,
+				Optional:     true,
+				ValidateFunc: validation.IntAtLeast(0),
 			},
 			"cmode": {
-				Type:     schema.TypeString,
-				Optional: true,
-				Default:  "tty",
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "tty",
+				ValidateFunc: validation.StringInSlice([]string{"tty", "console", "shell"}, false),
 			},
 			"console": {
 				Type:     schema.TypeBool,
@@ -41,18 +58,21 @@ func resourceLxc() *schema.Resource {
 				Default:  true,
 			},
 			"cores": {
-				Type:     schema.TypeInt,
-				Optional: true,
+				Type:         schema.TypeInt,
+				Optional:     true,
+				ValidateFunc: validation.IntBetween(1, 128),
 			},
 			"cpulimit": {
-				Type:     schema.TypeInt,
-				Optional: true,
-				Default:  0,
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntBetween(0, 128),
 			},
 			"cpuunits": {
-				Type:     schema.TypeInt,
-				Optional: true,
-				Default:  1024,
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      1024,
+				ValidateFunc: validation.IntBetween(8, 500000),
 			},
 			"description": {
 				Type:     schema.TypeString,
@@ -72,8 +92,9 @@ func resourceLxc() *schema.Resource {
 							Optional: true,
 						},
 						"mount": {
-							Type:     schema.TypeString,
-							Optional: true,
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.StringMatch(rxMountFsTypes, "must be a semicolon separated list of filesystem types, like nfs;cifs"),
 						},
 						"nesting": {
 							Type:     schema.TypeBool,
@@ -87,31 +108,40 @@ func resourceLxc() *schema.Resource {
 				Optional: true,
 			},
 			"hookscript": {
-				Type:     schema.TypeString,
-				Optional: true,
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringMatch(rxVolumeId, "must be a volume like local:snippets/hook.sh"),
 			},
 			"hostname": {
-				Type:     schema.TypeString,
-				Optional: true,
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateDnsName,
 			},
 			"ignore_unpack_errors": {
 				Type:     schema.TypeBool,
 				Optional: true,
 			},
 			"lock": {
-				Type:     schema.TypeString,
-				Optional: true,
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringInSlice([]string{"backup", "create", "destroyed", "disk", "fstrim", "migrate", "mounted", "rollback", "snapshot", "snapshot-delete"}, false),
 			},
 			"memory": {
-				Type:     schema.TypeInt,
-				Optional: true,
-				Default:  512,
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      512,
+				ValidateFunc: validation.IntAtLeast(16),
 			},
 			"mountpoint": {
 				Type:     schema.TypeSet,
 				Optional: true,
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
+						"id": {
+							Type:         schema.TypeInt,
+							Required:     true,
+							ValidateFunc: validation.IntBetween(0, 255),
+						},
 						"volume": {
 							Type:     schema.TypeString,
 							Required: true,
@@ -141,72 +171,91 @@ func resourceLxc() *schema.Resource {
 							Optional: true,
 						},
 						"size": {
-							Type:     schema.TypeInt,
-							Optional: true,
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validateDiskSize,
 						},
 					},
 				},
 			},
 			"nameserver": {
-				Type:     schema.TypeString,
-				Optional: true,
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateNameservers,
 			},
 			"network": {
 				Type:     schema.TypeSet,
 				Optional: true,
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
+						"id": {
+							Type:         schema.TypeInt,
+							Required:     true,
+							ValidateFunc: validation.IntBetween(0, 9),
+						},
 						"name": {
-							Type:     schema.TypeString,
-							Required: true,
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validation.StringMatch(rxInterfaceName, "must be a network interface name, like eth0"),
 						},
 						"bridge": {
-							Type:     schema.TypeString,
-							Optional: true,
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validateBridge,
 						},
 						"firewall": {
 							Type:     schema.TypeBool,
 							Optional: true,
 						},
 						"gw": {
-							Type:     schema.TypeString,
-							Optional: true,
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.IsIPv4Address,
 						},
 						"gw6": {
-							Type:     schema.TypeString,
-							Optional: true,
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.IsIPv6Address,
 						},
 						"hwaddr": {
-							Type:     schema.TypeString,
-							Optional: true,
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.IsMACAddress,
 						},
 						"ip": {
-							Type:     schema.TypeString,
-							Optional: true,
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validateIpOrKeyword("dhcp", "manual"),
 						},
 						"ip6": {
-							Type:     schema.TypeString,
-							Optional: true,
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validateIpOrKeyword("auto", "dhcp", "manual"),
 						},
 						"mtu": {
-							Type:     schema.TypeString,
-							Optional: true,
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validateMtu,
 						},
 						"rate": {
-							Type:     schema.TypeInt,
-							Optional: true,
+							Type:         schema.TypeInt,
+							Optional:     true,
+							ValidateFunc: validation.IntAtLeast(0),
 						},
 						"tag": {
-							Type:     schema.TypeInt,
-							Optional: true,
+							Type:         schema.TypeInt,
+							Optional:     true,
+							ValidateFunc: validation.IntBetween(1, 4094),
 						},
 						"trunks": {
-							Type:     schema.TypeString,
-							Optional: true,
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.StringMatch(rxVlanTrunks, "must be a semicolon separated list of VLAN tags or ranges, like 10;20-30"),
 						},
 						"type": {
-							Type:     schema.TypeString,
-							Optional: true,
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.StringInSlice([]string{"veth"}, false),
 						},
 					},
 				},
@@ -217,8 +266,9 @@ func resourceLxc() *schema.Resource {
 				Default:  false,
 			},
 			"ostype": {
-				Type:     schema.TypeString,
-				Optional: true,
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringInSlice([]string{"alpine", "archlinux", "centos", "debian", "fedora", "gentoo", "opensuse", "ubuntu", "unmanaged"}, false),
 			},
 			"password": {
 				Type:     schema.TypeString,
@@ -237,10 +287,7 @@ func resourceLxc() *schema.Resource {
 				Type:     schema.TypeBool,
 				Optional: true,
 			},
-			"rootfs": {
-				Type:     schema.TypeString,
-				Optional: true,
-			},
+			"rootfs": lxcRootFsSchema,
 			"searchdomain": {
 				Type:     schema.TypeString,
 				Optional: true,
@@ -249,14 +296,31 @@ func resourceLxc() *schema.Resource {
 				Type:     schema.TypeString,
 				Optional: true,
 			},
+			"shutdown_timeout": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      defaultShutdownTimeout,
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"force_stop": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+			"purge": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
 			"start": {
 				Type:     schema.TypeBool,
 				Optional: true,
 				Default:  false,
 			},
 			"startup": {
-				Type:     schema.TypeString,
-				Optional: true,
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateStartup,
 			},
 			"storage": {
 				Type:     schema.TypeString,
@@ -264,18 +328,20 @@ func resourceLxc() *schema.Resource {
 				Default:  "local",
 			},
 			"swap": {
-				Type:     schema.TypeInt,
-				Optional: true,
-				Default:  512,
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      512,
+				ValidateFunc: validation.IntAtLeast(0),
 			},
 			"template": {
 				Type:     schema.TypeBool,
 				Optional: true,
 			},
 			"tty": {
-				Type:     schema.TypeInt,
-				Optional: true,
-				Default:  2,
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      2,
+				ValidateFunc: validation.IntBetween(0, 6),
 			},
 			"unique": {
 				Type:     schema.TypeBool,
@@ -299,18 +365,32 @@ func resourceLxc() *schema.Resource {
 				ForceNew: true,
 			},
 			"vmid": {
-				Type:     schema.TypeInt,
-				Optional: true,
-				Default:  0,
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Computed:     true,
+				ForceNew:     true,
+				ValidateFunc: validateVmId,
 			},
 		},
 	}
+	resource.StateUpgraders = []schema.StateUpgrader{
+		{
+			Version: 0,
+			Type:    resourceLxcV0(resource.Schema).CoreConfigSchema().ImpliedType(),
+			Upgrade: resourceLxcStateUpgradeV0,
+		},
+		{
+			Version: 1,
+			Type:    resource.CoreConfigSchema().ImpliedType(),
+			Upgrade: resourceLxcStateUpgradeV1,
+		},
+	}
+	return resource
 }
 
 func resourceLxcCreate(d *schema.ResourceData, meta interface{}) error {
 	pconf := meta.(*providerConfiguration)
 	pmParallelBegin(pconf)
-	client := pconf.Client
 	vmName := d.Get("hostname").(string)
 
 	config := pxapi.NewConfigLxc()
@@ -340,7 +420,7 @@ func resourceLxcCreate(d *schema.ResourceData, meta interface{}) error {
 	// having a unique 'id' parameter foreach set
 	mountpoints := d.Get("mountpoint").(*schema.Set)
 	if len(mountpoints.List()) > 0 {
-		lxcMountpoints := DevicesSetToMapWithoutId(mountpoints)
+		lxcMountpoints := expandDiskSizes(expandLxcDevices(mountpoints))
 		config.Mountpoints = lxcMountpoints
 	}
 	config.Nameserver = d.Get("nameserver").(string)
@@ -348,7 +428,7 @@ func resourceLxcCreate(d *schema.ResourceData, meta interface{}) error {
 	// having a unique 'id' parameter foreach set
 	networks := d.Get("network").(*schema.Set)
 	if len(networks.List()) > 0 {
-		lxcNetworks := DevicesSetToMapWithoutId(networks)
+		lxcNetworks := expandLxcDevices(networks)
 		config.Networks = lxcNetworks
 	}
 	config.OnBoot = d.Get("onboot").(bool)
@@ -357,7 +437,9 @@ func resourceLxcCreate(d *schema.ResourceData, meta interface{}) error {
 	config.Pool = d.Get("pool").(string)
 	config.Protection = d.Get("protection").(bool)
 	config.Restore = d.Get("restore").(bool)
-	config.RootFs = d.Get("rootfs").(string)
+	if rootFs := d.Get("rootfs").([]interface{}); len(rootFs) > 0 {
+		config.RootFs = expandLxcRootFs(rootFs[0].(map[string]interface{}), "")
+	}
 	config.SearchDomain = d.Get("searchdomain").(string)
 	config.SSHPublicKeys = d.Get("ssh_public_keys").(string)
 	config.Start = d.Get("start").(bool)
@@ -381,20 +463,20 @@ func resourceLxcCreate(d *schema.ResourceData, meta interface{}) error {
 	//vmr, _ := client.GetVmRefByName(vmName)
 
 	// get unique id
-	nextid, err := nextVmId(pconf)
-	vmID := d.Get("vmid").(int)
-	if vmID != 0 {
-		nextid = vmID
-	} else {
+	nextid := d.Get("vmid").(int)
+	if nextid == 0 {
+		var err error
+		nextid, err = nextVmId(pconf)
 		if err != nil {
 			pmParallelEnd(pconf)
 			return err
 		}
+		defer releaseVmId(pconf, nextid)
 	}
 
 	vmr := pxapi.NewVmRef(nextid)
 	vmr.SetNode(targetNode)
-	err = config.CreateLxc(vmr, client)
+	err := createLxc(pconf, config, vmr, d.Timeout(schema.TimeoutCreate))
 	if err != nil {
 		pmParallelEnd(pconf)
 		return err
@@ -403,12 +485,14 @@ func resourceLxcCreate(d *schema.ResourceData, meta interface{}) error {
 	// The existence of a non-blank ID is what tells Terraform that a resource was created
 	d.SetId(resourceId(targetNode, "lxc", vmr.VmId()))
 
+	pmParallelEnd(pconf)
 	return resourceLxcRead(d, meta)
 }
 
 func resourceLxcUpdate(d *schema.ResourceData, meta interface{}) error {
 	pconf := meta.(*providerConfiguration)
 	pmParallelBegin(pconf)
+	deadline := time.Now().Add(d.Timeout(schema.TimeoutUpdate))
 	client := pconf.Client
 
 	_, _, vmID, err := parseResourceId(d.Id())
@@ -450,7 +534,7 @@ func resourceLxcUpdate(d *schema.ResourceData, meta interface{}) error {
 	// having a unique 'id' parameter foreach set
 	mountpoints := d.Get("mountpoint").(*schema.Set)
 	if len(mountpoints.List()) > 0 {
-		lxcMountpoints := DevicesSetToMapWithoutId(mountpoints)
+		lxcMountpoints := expandDiskSizes(expandLxcDevices(mountpoints))
 		config.Mountpoints = lxcMountpoints
 	}
 	config.Nameserver = d.Get("nameserver").(string)
@@ -458,7 +542,7 @@ func resourceLxcUpdate(d *schema.ResourceData, meta interface{}) error {
 	// having a unique 'id' parameter foreach set
 	networks := d.Get("network").(*schema.Set)
 	if len(networks.List()) > 0 {
-		lxcNetworks := DevicesSetToMapWithoutId(networks)
+		lxcNetworks := expandLxcDevices(networks)
 		config.Networks = lxcNetworks
 	}
 	config.OnBoot = d.Get("onboot").(bool)
@@ -467,7 +551,6 @@ func resourceLxcUpdate(d *schema.ResourceData, meta interface{}) error {
 	config.Pool = d.Get("pool").(string)
 	config.Protection = d.Get("protection").(bool)
 	config.Restore = d.Get("restore").(bool)
-	config.RootFs = d.Get("rootfs").(string)
 	config.SearchDomain = d.Get("searchdomain").(string)
 	config.SSHPublicKeys = d.Get("ssh_public_keys").(string)
 	config.Start = d.Get("start").(bool)
@@ -487,12 +570,62 @@ func resourceLxcUpdate(d *schema.ResourceData, meta interface{}) error {
 	}
 	config.Unused = volumes
 
-	err = config.UpdateConfig(vmr, client)
+	// Detach removed mountpoints and networks first, so their ids can be
+	// reused. Detached volumes are kept as unused volumes.
+	var removed []string
+	if d.HasChange("mountpoint") {
+		oldMountpoints, newMountpoints := d.GetChange("mountpoint")
+		removed = append(removed, removedDeviceKeys("mp", oldMountpoints.(*schema.Set), newMountpoints.(*schema.Set))...)
+	}
+	if d.HasChange("network") {
+		oldNetworks, newNetworks := d.GetChange("network")
+		removed = append(removed, removedDeviceKeys("net", oldNetworks.(*schema.Set), newNetworks.(*schema.Set))...)
+	}
+	if len(removed) > 0 {
+		log.Printf("[DEBUG] detaching %s", strings.Join(removed, ", "))
+		err = pconf.Retry.Do(func() error {
+			_, err := pconf.Session.Put(
+				fmt.Sprintf("/nodes/%s/lxc/%d/config", vmr.Node(), vmr.VmId()),
+				map[string]interface{}{"delete": strings.Join(removed, ",")},
+			)
+			return err
+		})
+		if err != nil {
+			pmParallelEnd(pconf)
+			return err
+		}
+	}
+
+	// Resize before UpdateConfig, which also writes the sizes to the config
+	// without resizing the volumes.
+	rootFs := d.Get("rootfs").([]interface{})
+	var rootFsMap map[string]interface{}
+	if len(rootFs) > 0 {
+		rootFsMap = rootFs[0].(map[string]interface{})
+	}
+	vmConfig, err := prepareLxcDiskSize(pconf, vmr, rootFsMap, config.Mountpoints, deadline)
+	if err != nil {
+		pmParallelEnd(pconf)
+		return err
+	}
+	if current, ok := vmConfig["rootfs"].(string); ok && rootFsMap != nil {
+		config.RootFs = expandLxcRootFs(rootFsMap, strings.TrimPrefix(strings.Split(current, ",")[0], "volume="))
+	}
+
+	err = pconf.Retry.Do(func() error { return config.UpdateConfig(vmr, client) })
+	if err != nil {
+		pmParallelEnd(pconf)
+		return err
+	}
+
+	// Unlike updateVmConfig, ConfigLxc.UpdateConfig leaves the pool alone.
+	err = moveVmPool(pconf, vmr.VmId(), vmr.Pool(), config.Pool)
 	if err != nil {
 		pmParallelEnd(pconf)
 		return err
 	}
 
+	pmParallelEnd(pconf)
 	return nil
 }
 
@@ -519,6 +652,7 @@ func resourceLxcRead(d *schema.ResourceData, meta interface{}) error {
 	}
 	d.SetId(resourceId(vmr.Node(), "lxc", vmr.VmId()))
 	d.Set("target_node", vmr.Node())
+	d.Set("vmid", vmr.VmId())
 
 	d.Set("arch", config.Arch)
 	d.Set("bwlimit", config.BWLimit)
@@ -543,20 +677,21 @@ func resourceLxcRead(d *schema.ResourceData, meta interface{}) error {
 	d.Set("memory", config.Memory)
 
 	configMountpointSet := d.Get("mountpoint").(*schema.Set)
-	configMountpointSet = AddIds(configMountpointSet)
 	if len(configMountpointSet.List()) > 0 {
+		for _, mountpoint := range config.Mountpoints {
+			// The volume comes without key, the configured one is kept.
+			delete(mountpoint, "")
+		}
+		flattenDiskSizes(config.Mountpoints, expandDevices(configMountpointSet))
 		activeMountpointSet := flattenDevices(configMountpointSet, config.Mountpoints)
-		activeMountpointSet = RemoveIds(activeMountpointSet)
 		d.Set("mountpoint", activeMountpointSet)
 	}
 
 	d.Set("nameserver", config.Nameserver)
 
 	configNetworksSet := d.Get("network").(*schema.Set)
-	configNetworksSet = AddIds(configNetworksSet)
 	if len(configNetworksSet.List()) > 0 {
 		activeNetworksSet := flattenDevices(configNetworksSet, config.Networks)
-		activeNetworksSet = RemoveIds(activeNetworksSet)
 		d.Set("network", activeNetworksSet)
 	}
 
@@ -564,10 +699,10 @@ func resourceLxcRead(d *schema.ResourceData, meta interface{}) error {
 	d.Set("ostemplate", config.Ostemplate)
 	d.Set("ostype", config.OsType)
 	d.Set("password", config.Password)
-	d.Set("pool", config.Pool)
+	d.Set("pool", vmr.Pool())
 	d.Set("protection", config.Protection)
 	d.Set("restore", config.Restore)
-	d.Set("rootfs", config.RootFs)
+	d.Set("rootfs", flattenLxcRootFs(config.RootFs, d.Get("rootfs").([]interface{})))
 	d.Set("searchdomain", config.SearchDomain)
 	d.Set("ssh_public_keys", config.SSHPublicKeys)
 	d.Set("start", config.Start)
@@ -583,3 +718,181 @@ func resourceLxcRead(d *schema.ResourceData, meta interface{}) error {
 	pmParallelEnd(pconf)
 	return nil
 }
+
+func resourceLxcDelete(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	pmParallelBegin(pconf)
+	defer pmParallelEnd(pconf)
+	deadline := time.Now().Add(d.Timeout(schema.TimeoutDelete))
+
+	client := pconf.Client
+	_, _, vmID, err := parseResourceId(d.Id())
+	if err != nil {
+		return err
+	}
+	vmr := pxapi.NewVmRef(vmID)
+	// Looked up again, the container may have been migrated.
+	_, err = client.GetVmInfo(vmr)
+	if err != nil {
+		if strings.HasSuffix(err.Error(), "not found") {
+			log.Printf("[DEBUG] container %d is already gone", vmID)
+			return nil
+		}
+		return err
+	}
+	if vmr.GetVmType() != "lxc" {
+		return fmt.Errorf("VMID %d is a %s VM, not a container", vmID, vmr.GetVmType())
+	}
+
+	vmConfig, err := client.GetVmConfig(vmr)
+	if err != nil {
+		return err
+	}
+	if protection, _ := vmConfig["protection"].(float64); protection == 1 {
+		return fmt.Errorf("Container %d is protected, set protection to false before destroying it", vmID)
+	}
+
+	running, err := vmIsRunning(pconf, vmr)
+	if err != nil {
+		return err
+	}
+	if running {
+		err = shutdownVm(pconf, d, vmr, time.Until(deadline))
+		if err != nil {
+			return err
+		}
+	}
+	return deleteVm(pconf, vmr, d.Get("purge").(bool), time.Until(deadline))
+}
+
+// resourceLxcCustomizeDiff rejects shrinking the rootfs or a mountpoint, which
+// Proxmox cannot do.
+func resourceLxcCustomizeDiff(d *schema.ResourceDiff, meta interface{}) error {
+	if d.Id() == "" {
+		return nil
+	}
+	if d.HasChange("rootfs.0.size") {
+		oldSizeValue, newSizeValue := d.GetChange("rootfs.0.size")
+		oldSize, oldErr := parseDiskSize(oldSizeValue.(string))
+		newSize, newErr := parseDiskSize(newSizeValue.(string))
+		if oldErr == nil && newErr == nil && newSize < oldSize {
+			return fmt.Errorf("rootfs cannot be shrunk from %s to %s", oldSize, newSize)
+		}
+	}
+	if d.HasChange("mountpoint") {
+		oldMountpoints, newMountpoints := d.GetChange("mountpoint")
+		oldMountpointsMap := expandDevices(oldMountpoints.(*schema.Set))
+		for mpID, mountpoint := range expandDevices(newMountpoints.(*schema.Set)) {
+			oldMountpoint, ok := oldMountpointsMap[mpID]
+			if !ok {
+				continue
+			}
+			oldSize, oldOk := lxcMountpointSize(oldMountpoint)
+			size, ok := lxcMountpointSize(mountpoint)
+			if oldOk && ok && size < oldSize {
+				return fmt.Errorf("Mountpoint mp%d cannot be shrunk from %s to %s", mpID, oldSize, size)
+			}
+		}
+	}
+	return nil
+}
+
+// A new volume of SIZE gigabytes on STORAGE, like local-lvm:8.
+var rxNewLxcVolume = regexp.MustCompile(`^[^:,=]+:([0-9]+(\.[0-9]+)?)$`)
+
+// lxcVolumeSize returns the size of a rootfs or mountpoint in the format of
+// Proxmox, like local-lvm:vm-100-disk-0,size=8G, or of a new volume like
+// local-lvm:8. parse parses the size option.
+func lxcVolumeSize(volume string, parse func(string) (diskSize, error)) (diskSize, bool) {
+	options := strings.Split(volume, ",")
+	if match := rxNewLxcVolume.FindStringSubmatch(strings.TrimPrefix(options[0], "volume=")); match != nil {
+		size, err := parseDiskSize(match[1])
+		return size, err == nil
+	}
+	for _, option := range options[1:] {
+		if strings.HasPrefix(option, "size=") {
+			size, err := parse(strings.TrimPrefix(option, "size="))
+			return size, err == nil
+		}
+	}
+	return 0, false
+}
+
+// lxcMountpointSize returns the size of a mountpoint block, from its size or
+// else its volume.
+func lxcMountpointSize(mountpoint map[string]interface{}) (diskSize, bool) {
+	if size, _ := mountpoint["size"].(string); size != "" {
+		parsed, err := parseDiskSize(size)
+		return parsed, err == nil
+	}
+	volume, _ := mountpoint["volume"].(string)
+	return lxcVolumeSize(volume, parseDiskSize)
+}
+
+// prepareLxcDiskSize grows the rootfs and mountpoints of the container to their
+// configured size through the resize endpoint, as prepareDiskSize does for
+// QEMU, and returns the config of the container after resizing. Existing
+// mountpoints written like local-lvm:8 would be allocated anew by the config
+// update, so their existing volume is set instead.
+func prepareLxcDiskSize(
+	pconf *providerConfiguration,
+	vmr *pxapi.VmRef,
+	rootFs map[string]interface{},
+	mountpoints pxapi.QemuDevices,
+	deadline time.Time,
+) (map[string]interface{}, error) {
+	vmConfig, err := pconf.Client.GetVmConfig(vmr)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := map[string]diskSize{}
+	if rootFs != nil {
+		if size, err := parseDiskSize(rootFs["size"].(string)); err == nil {
+			sizes["rootfs"] = size
+		}
+	}
+	for mpID, mountpoint := range mountpoints {
+		if size, ok := lxcMountpointSize(mountpoint); ok {
+			sizes[fmt.Sprintf("mp%d", mpID)] = size
+		}
+	}
+
+	resized := false
+	for volumeName, size := range sizes {
+		current, ok := vmConfig[volumeName].(string)
+		if !ok {
+			// New mountpoints are created with their size.
+			continue
+		}
+		currentSize, ok := lxcVolumeSize(current, parseApiSize)
+		if !ok {
+			continue
+		}
+		if size > currentSize {
+			log.Printf("[DEBUG] resizing %s from %s to %s", volumeName, currentSize, size)
+			err = resizeVmDisk(pconf, vmr, volumeName, size, time.Until(deadline))
+			if err != nil {
+				return nil, err
+			}
+			resized = true
+		} else if size < currentSize {
+			return nil, fmt.Errorf("%s is %s, it cannot be shrunk to %s", volumeName, currentSize, size)
+		}
+	}
+	if resized {
+		vmConfig, err = pconf.Client.GetVmConfig(vmr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for mpID, mountpoint := range mountpoints {
+		current, ok := vmConfig[fmt.Sprintf("mp%d", mpID)].(string)
+		volume, _ := mountpoint["volume"].(string)
+		if ok && rxNewLxcVolume.MatchString(volume) {
+			mountpoint["volume"] = strings.TrimPrefix(strings.Split(current, ",")[0], "volume=")
+		}
+	}
+	return vmConfig, nil
+}
@@ -0,0 +1,105 @@
+package proxmox
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+// resourceLxcStateUpgradeV0 turns the rootfs string of schema version 0 into a
+// rootfs block.
+func resourceLxcStateUpgradeV0(rawState map[string]interface{}, meta interface{}) (map[string]interface{}, error) {
+	if rootFs, ok := rawState["rootfs"].(string); ok {
+		if rootFs == "" {
+			rawState["rootfs"] = []interface{}{}
+		} else {
+			rawState["rootfs"] = flattenLxcRootFs(rootFs, nil)
+		}
+	}
+	return rawState, nil
+}
+
+// resourceLxcV0 is the resource of schema version 0, with rootfs as a string.
+// Its other arguments are the same as those of schemaV1.
+func resourceLxcV0(schemaV1 map[string]*schema.Schema) *schema.Resource {
+	schemaV0 := map[string]*schema.Schema{}
+	for key, value := range schemaV1 {
+		schemaV0[key] = value
+	}
+	schemaV0["rootfs"] = &schema.Schema{
+		Type:     schema.TypeString,
+		Optional: true,
+	}
+	return &schema.Resource{Schema: schemaV0}
+}
+
+// resourceLxcStateUpgradeV1 sets the ids mountpoints and networks got in
+// schema version 2. Before, they were numbered in the order of their set, so
+// the ids are looked up in the container by mount path and interface name.
+func resourceLxcStateUpgradeV1(rawState map[string]interface{}, meta interface{}) (map[string]interface{}, error) {
+	mountpoints, _ := rawState["mountpoint"].([]interface{})
+	networks, _ := rawState["network"].([]interface{})
+	if len(mountpoints) == 0 && len(networks) == 0 {
+		return rawState, nil
+	}
+	id, _ := rawState["id"].(string)
+	_, _, vmID, err := parseResourceId(id)
+	if err != nil {
+		return nil, err
+	}
+	// Without a configured provider, like when only the state is read, the
+	// devices get id -1 until they are set in the config.
+	var vmConfig map[string]interface{}
+	if pconf, ok := meta.(*providerConfiguration); ok && pconf != nil && pconf.Client != nil {
+		vmr := pxapi.NewVmRef(vmID)
+		vmConfig, err = pconf.Client.GetVmConfig(vmr)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot look up the mountpoint and network ids of container %d: %v", vmID, err)
+		}
+	} else {
+		log.Printf("[WARN] cannot look up the mountpoint and network ids of container %d without provider", vmID)
+	}
+	upgradeLxcDeviceIds(vmConfig, mountpoints, "mp", "mp")
+	upgradeLxcDeviceIds(vmConfig, networks, "net", "name")
+	return rawState, nil
+}
+
+// upgradeLxcDeviceIds sets the id of each device to that of the prefixed key in
+// vmConfig with the same value for option, or -1 when there is none.
+func upgradeLxcDeviceIds(vmConfig map[string]interface{}, devices []interface{}, prefix string, option string) {
+	for _, raw := range devices {
+		device, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		device["id"] = -1
+		for key, value := range vmConfig {
+			var id int
+			if _, err := fmt.Sscanf(key, prefix+"%d", &id); err != nil || key != fmt.Sprintf("%s%d", prefix, id) {
+				continue
+			}
+			options, _ := value.(string)
+			if lxcDeviceOption(options, option) == device[option] {
+				device["id"] = id
+				break
+			}
+		}
+		if device["id"] == -1 {
+			log.Printf("[WARN] %s %v not found in the container, set its id", prefix, device[option])
+		}
+	}
+}
+
+// lxcDeviceOption returns an option of a mountpoint or network of Proxmox, like
+// the name of name=eth0,bridge=vmbr0.
+func lxcDeviceOption(options string, option string) string {
+	for _, keyValue := range strings.Split(options, ",") {
+		if strings.HasPrefix(keyValue, option+"=") {
+			return strings.TrimPrefix(keyValue, option+"=")
+		}
+	}
+	return ""
+}
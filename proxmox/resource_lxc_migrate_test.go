@@ -0,0 +1,191 @@
+package proxmox
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+func TestResourceLxcStateUpgradeV0(t *testing.T) {
+	tests := []struct {
+		name     string
+		rootFs   interface{}
+		expected interface{}
+	}{
+		{
+			"allocated volume",
+			"local-lvm:vm-100-disk-0,size=8G,acl=1",
+			[]interface{}{map[string]interface{}{
+				"storage":      "local-lvm",
+				"size":         "8G",
+				"acl":          true,
+				"quota":        false,
+				"replicate":    true,
+				"ro":           false,
+				"mountoptions": "",
+				"volume":       "local-lvm:vm-100-disk-0",
+			}},
+		},
+		{
+			"new volume",
+			"local-lvm:8",
+			[]interface{}{map[string]interface{}{
+				"storage":      "local-lvm",
+				"size":         "8G",
+				"acl":          false,
+				"quota":        false,
+				"replicate":    true,
+				"ro":           false,
+				"mountoptions": "",
+				"volume":       "",
+			}},
+		},
+		{"empty", "", []interface{}{}},
+		{"already upgraded", []interface{}{}, []interface{}{}},
+		{"missing", nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rawState := map[string]interface{}{"id": "pve/lxc/100", "hostname": "web-1"}
+			if test.rootFs != nil {
+				rawState["rootfs"] = test.rootFs
+			}
+			upgraded, err := resourceLxcStateUpgradeV0(rawState, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(upgraded["rootfs"], test.expected) {
+				t.Errorf("expected rootfs %v, got %v", test.expected, upgraded["rootfs"])
+			}
+			if upgraded["hostname"] != "web-1" {
+				t.Errorf("expected the other attributes to be kept, got %v", upgraded)
+			}
+		})
+	}
+}
+
+func TestResourceLxcV0(t *testing.T) {
+	schemaV1 := resourceLxc().Schema
+	schemaV0 := resourceLxcV0(schemaV1).Schema
+	if schemaV0["rootfs"].Type != schema.TypeString {
+		t.Errorf("expected rootfs to be a string in version 0, got %s", schemaV0["rootfs"].Type)
+	}
+	if schemaV1["rootfs"].Type != schema.TypeList {
+		t.Error("expected the schema of the current version to be left alone")
+	}
+	if schemaV0["hostname"] != schemaV1["hostname"] {
+		t.Error("expected the other arguments to be shared")
+	}
+}
+
+func testLxcStateV1() map[string]interface{} {
+	return map[string]interface{}{
+		"id":         "pve/lxc/100",
+		"mountpoint": []interface{}{map[string]interface{}{"mp": "/srv"}},
+		"network":    []interface{}{map[string]interface{}{"name": "eth0"}},
+	}
+}
+
+func TestResourceLxcStateUpgradeV1(t *testing.T) {
+	server := newFakeApiServer()
+	defer server.Close()
+	server.handle("GET /cluster/resources", func(w http.ResponseWriter, r *http.Request) {
+		writeApiData(w, []interface{}{
+			map[string]interface{}{"vmid": 100, "node": "pve", "type": "lxc"},
+		})
+	})
+	server.handle("GET /nodes/pve/lxc/100/config", func(w http.ResponseWriter, r *http.Request) {
+		writeApiData(w, map[string]interface{}{
+			"mp2":  "local-lvm:vm-100-disk-1,mp=/srv,size=20G",
+			"net1": "name=eth0,bridge=vmbr0,ip=dhcp",
+		})
+	})
+	pconf := server.config()
+	client, err := pxapi.NewClient(server.URL+"/api2/json", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pconf.Client = client
+
+	tests := []struct {
+		name         string
+		meta         interface{}
+		mountpointId int
+		networkId    int
+	}{
+		{"looked up", pconf, 2, 1},
+		// Without a configured provider, the ids are left for the user to set.
+		{"no provider", nil, -1, -1},
+		{"provider not configured", &providerConfiguration{}, -1, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upgraded, err := resourceLxcStateUpgradeV1(testLxcStateV1(), test.meta)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			mountpoint := upgraded["mountpoint"].([]interface{})[0].(map[string]interface{})
+			network := upgraded["network"].([]interface{})[0].(map[string]interface{})
+			if mountpoint["id"] != test.mountpointId || network["id"] != test.networkId {
+				t.Errorf("expected ids %d and %d, got %v and %v", test.mountpointId, test.networkId, mountpoint["id"], network["id"])
+			}
+		})
+	}
+}
+
+func TestUpgradeLxcDeviceIds(t *testing.T) {
+	vmConfig := map[string]interface{}{
+		"rootfs": "local-lvm:vm-100-disk-0,size=8G",
+		"mp0":    "local-lvm:vm-100-disk-1,mp=/var/lib/data,size=10G",
+		"mp3":    "local-lvm:vm-100-disk-2,mp=/srv,size=20G",
+		"mp3x":   "local-lvm:vm-100-disk-3,mp=/opt,size=1G",
+		"net0":   "name=eth0,bridge=vmbr0,ip=dhcp",
+		"net2":   "name=eth1,bridge=vmbr1,ip=10.0.0.2/24",
+	}
+	mountpoints := []interface{}{
+		map[string]interface{}{"mp": "/srv"},
+		map[string]interface{}{"mp": "/var/lib/data"},
+		map[string]interface{}{"mp": "/opt"},
+	}
+	networks := []interface{}{
+		map[string]interface{}{"name": "eth1"},
+		map[string]interface{}{"name": "eth0"},
+	}
+	upgradeLxcDeviceIds(vmConfig, mountpoints, "mp", "mp")
+	upgradeLxcDeviceIds(vmConfig, networks, "net", "name")
+
+	// Devices which are not in the container get id -1.
+	for i, expected := range []int{3, 0, -1} {
+		if id := mountpoints[i].(map[string]interface{})["id"]; id != expected {
+			t.Errorf("mountpoint %d: expected id %d, got %v", i, expected, id)
+		}
+	}
+	for i, expected := range []int{2, 0} {
+		if id := networks[i].(map[string]interface{})["id"]; id != expected {
+			t.Errorf("network %d: expected id %d, got %v", i, expected, id)
+		}
+	}
+}
+
+func TestLxcDeviceOption(t *testing.T) {
+	tests := []struct {
+		options  string
+		option   string
+		expected string
+	}{
+		{"name=eth0,bridge=vmbr0,ip=dhcp", "name", "eth0"},
+		{"name=eth0,bridge=vmbr0,ip=dhcp", "bridge", "vmbr0"},
+		{"local-lvm:vm-100-disk-1,mp=/srv,size=20G", "mp", "/srv"},
+		{"name=eth0,hwaddr=AA:BB:CC:DD:EE:FF", "hw", ""},
+		{"name=eth0", "ip", ""},
+		{"", "name", ""},
+	}
+	for _, test := range tests {
+		if value := lxcDeviceOption(test.options, test.option); value != test.expected {
+			t.Errorf("lxcDeviceOption(%q, %q): expected %q, got %q", test.options, test.option, test.expected, value)
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package proxmox
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
+)
+
+// VLAN interfaces can also be named after their raw device and tag, like
+// eno1.100, instead of setting vlan_raw_device and vlan_id.
+var rxVlanInterfaceName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*\.[0-9]+$`)
+
+func resourceNetworkBridge() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceNetworkBridgeCreate,
+		Read:   resourceNetworkBridgeRead,
+		Update: resourceNetworkBridgeUpdate,
+		Delete: resourceNetworkBridgeDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultTimeout),
+			Update: schema.DefaultTimeout(defaultTimeout),
+			Delete: schema.DefaultTimeout(defaultTimeout),
+		},
+		CustomizeDiff: resourceNetworkBridgeCustomizeDiff,
+
+		Schema: map[string]*schema.Schema{
+			"target_node": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"name": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				ValidateFunc: validation.StringMatch(rxInterfaceName, "must be a network interface name, like vmbr1"),
+			},
+			"type": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				Default:      "bridge",
+				ValidateFunc: validation.StringInSlice([]string{"bridge", "bond", "vlan"}, false),
+			},
+			"autostart": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+			"comment": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"cidr": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.IsCIDR,
+			},
+			"gateway": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.IsIPv4Address,
+			},
+			"cidr6": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.IsCIDR,
+			},
+			"gateway6": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.IsIPv6Address,
+			},
+			"mtu": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				ValidateFunc: validation.IntBetween(1280, 65520),
+			},
+			"ports": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validation.StringMatch(rxInterfaceName, "must be a network interface name"),
+				},
+			},
+			"vlan_aware": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"bond_mode": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ValidateFunc: validation.StringInSlice([]string{
+					"balance-rr", "active-backup", "balance-xor", "broadcast", "802.3ad", "balance-tlb", "balance-alb",
+				}, false),
+			},
+			"slaves": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validation.StringMatch(rxInterfaceName, "must be a network interface name"),
+				},
+			},
+			"bond_primary": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringMatch(rxInterfaceName, "must be a network interface name"),
+			},
+			"bond_hash_policy": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringInSlice([]string{"layer2", "layer2+3", "layer3+4"}, false),
+			},
+			"vlan_id": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Computed:     true,
+				ValidateFunc: validation.IntBetween(1, 4094),
+			},
+			"vlan_raw_device": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				ValidateFunc: validation.StringMatch(rxInterfaceName, "must be a network interface name"),
+			},
+			"active": {
+				Type:     schema.TypeBool,
+				Computed: true,
+			},
+		},
+	}
+}
+
+// networkArguments maps the arguments to the parameters of the Proxmox network
+// API, per interface type. The remaining arguments apply to every type.
+var networkArguments = map[string]map[string]string{
+	"bridge": {
+		"ports":      "bridge_ports",
+		"vlan_aware": "bridge_vlan_aware",
+	},
+	"bond": {
+		"bond_mode":        "bond_mode",
+		"slaves":           "slaves",
+		"bond_primary":     "bond-primary",
+		"bond_hash_policy": "bond_xmit_hash_policy",
+	},
+	"vlan": {
+		"vlan_id":         "vlan-id",
+		"vlan_raw_device": "vlan-raw-device",
+	},
+}
+
+var networkCommonArguments = map[string]string{
+	"autostart": "autostart",
+	"comment":   "comments",
+	"cidr":      "cidr",
+	"gateway":   "gateway",
+	"cidr6":     "cidr6",
+	"gateway6":  "gateway6",
+	"mtu":       "mtu",
+}
+
+func resourceNetworkBridgeCreate(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	pmParallelBegin(pconf)
+	defer pmParallelEnd(pconf)
+	pconf.NetworkMutex.Lock()
+	defer pconf.NetworkMutex.Unlock()
+
+	node := d.Get("target_node").(string)
+	name := d.Get("name").(string)
+	params, _ := networkParams(d)
+	params["iface"] = name
+	err := pconf.Retry.Do(func() error {
+		_, err := pconf.Session.Post(fmt.Sprintf("/nodes/%s/network", node), params)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	// Staged, so the interface is managed even when applying it fails.
+	d.SetId(networkId(node, name))
+
+	err = reloadNetwork(pconf, node, d.Timeout(schema.TimeoutCreate))
+	if err != nil {
+		return err
+	}
+	return resourceNetworkBridgeRead(d, meta)
+}
+
+func resourceNetworkBridgeUpdate(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	pmParallelBegin(pconf)
+	defer pmParallelEnd(pconf)
+	pconf.NetworkMutex.Lock()
+	defer pconf.NetworkMutex.Unlock()
+
+	node, name, err := parseNetworkId(d.Id())
+	if err != nil {
+		return err
+	}
+	params, deletes := networkParams(d)
+	if len(deletes) > 0 {
+		params["delete"] = strings.Join(deletes, ",")
+	}
+	err = pconf.Retry.Do(func() error {
+		_, err := pconf.Session.Put(fmt.Sprintf("/nodes/%s/network/%s", node, name), params)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = reloadNetwork(pconf, node, d.Timeout(schema.TimeoutUpdate))
+	if err != nil {
+		return err
+	}
+	return resourceNetworkBridgeRead(d, meta)
+}
+
+func resourceNetworkBridgeRead(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	node, name, err := parseNetworkId(d.Id())
+	if err != nil {
+		return err
+	}
+	iface, err := getNetworkInterface(pconf, node, name)
+	if err != nil {
+		return err
+	}
+	if iface == nil {
+		log.Printf("[WARN] network interface %s on node %s is gone, removing it from state", name, node)
+		d.SetId("")
+		return nil
+	}
+
+	ifaceType, _ := iface["type"].(string)
+	d.Set("target_node", node)
+	d.Set("name", name)
+	d.Set("type", ifaceType)
+	d.Set("autostart", apiInt(iface["autostart"]) == 1)
+	d.Set("comment", strings.TrimSuffix(apiString(iface["comments"]), "\n"))
+	d.Set("cidr", apiString(iface["cidr"]))
+	d.Set("gateway", apiString(iface["gateway"]))
+	d.Set("cidr6", apiString(iface["cidr6"]))
+	d.Set("gateway6", apiString(iface["gateway6"]))
+	d.Set("mtu", apiInt(iface["mtu"]))
+	d.Set("ports", strings.Fields(apiString(iface["bridge_ports"])))
+	d.Set("vlan_aware", apiInt(iface["bridge_vlan_aware"]) == 1)
+	d.Set("bond_mode", apiString(iface["bond_mode"]))
+	d.Set("slaves", strings.Fields(apiString(iface["slaves"])))
+	d.Set("bond_primary", apiString(iface["bond-primary"]))
+	d.Set("bond_hash_policy", apiString(iface["bond_xmit_hash_policy"]))
+	d.Set("vlan_id", apiInt(iface["vlan-id"]))
+	d.Set("vlan_raw_device", apiString(iface["vlan-raw-device"]))
+	d.Set("active", apiInt(iface["active"]) == 1)
+	return nil
+}
+
+func resourceNetworkBridgeDelete(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	pmParallelBegin(pconf)
+	defer pmParallelEnd(pconf)
+	pconf.NetworkMutex.Lock()
+	defer pconf.NetworkMutex.Unlock()
+
+	node, name, err := parseNetworkId(d.Id())
+	if err != nil {
+		return err
+	}
+	iface, err := getNetworkInterface(pconf, node, name)
+	if err != nil {
+		return err
+	}
+	if iface == nil {
+		log.Printf("[DEBUG] network interface %s on node %s is already gone", name, node)
+		return nil
+	}
+	err = pconf.Retry.Do(func() error {
+		_, err := pconf.Session.Delete(fmt.Sprintf("/nodes/%s/network/%s", node, name), nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return reloadNetwork(pconf, node, d.Timeout(schema.TimeoutDelete))
+}
+
+// resourceNetworkBridgeCustomizeDiff rejects arguments which do not apply to
+// the type of interface, which Proxmox would silently ignore.
+func resourceNetworkBridgeCustomizeDiff(d *schema.ResourceDiff, meta interface{}) error {
+	ifaceType := d.Get("type").(string)
+	for argType, arguments := range networkArguments {
+		if argType == ifaceType {
+			continue
+		}
+		for argument := range arguments {
+			if _, ok := d.GetOk(argument); ok {
+				return fmt.Errorf("%s only applies to interfaces of type %s, not %s", argument, argType, ifaceType)
+			}
+		}
+	}
+	switch ifaceType {
+	case "bond":
+		if len(d.Get("slaves").([]interface{})) == 0 {
+			return fmt.Errorf("A bond needs slaves")
+		}
+		mode := d.Get("bond_mode").(string)
+		if _, ok := d.GetOk("bond_primary"); ok && mode != "active-backup" {
+			return fmt.Errorf("bond_primary only applies to bond_mode active-backup")
+		}
+		if _, ok := d.GetOk("bond_hash_policy"); ok && mode != "balance-xor" && mode != "802.3ad" {
+			return fmt.Errorf("bond_hash_policy only applies to bond_mode balance-xor and 802.3ad")
+		}
+	case "vlan":
+		_, hasId := d.GetOk("vlan_id")
+		_, hasDevice := d.GetOk("vlan_raw_device")
+		if hasId != hasDevice {
+			return fmt.Errorf("vlan_id and vlan_raw_device must be set together")
+		}
+		if !hasId && !rxVlanInterfaceName.MatchString(d.Get("name").(string)) {
+			return fmt.Errorf("A VLAN interface needs vlan_id and vlan_raw_device, unless it is named like eno1.100")
+		}
+	}
+	return nil
+}
+
+// networkParams returns the parameters of the interface for Proxmox, and the
+// parameters to delete because their argument is unset.
+func networkParams(d *schema.ResourceData) (map[string]interface{}, []string) {
+	ifaceType := d.Get("type").(string)
+	params := map[string]interface{}{"type": ifaceType}
+	var deletes []string
+	add := func(argument string, param string) {
+		switch value := d.Get(argument).(type) {
+		case []interface{}:
+			var names []string
+			for _, name := range value {
+				names = append(names, name.(string))
+			}
+			if len(names) > 0 {
+				params[param] = strings.Join(names, " ")
+			} else {
+				deletes = append(deletes, param)
+			}
+		case bool:
+			params[param] = value
+		case int:
+			if value > 0 {
+				params[param] = value
+			} else {
+				deletes = append(deletes, param)
+			}
+		case string:
+			if value != "" {
+				params[param] = value
+			} else {
+				deletes = append(deletes, param)
+			}
+		}
+	}
+	for argument, param := range networkCommonArguments {
+		add(argument, param)
+	}
+	for argument, param := range networkArguments[ifaceType] {
+		add(argument, param)
+	}
+	sort.Strings(deletes)
+	return params, deletes
+}
+
+// getNetworkInterface returns the configuration of the interface, including
+// changes not applied yet. It returns nil when there is no such interface.
+func getNetworkInterface(pconf *providerConfiguration, node string, name string) (map[string]interface{}, error) {
+	return apiListItem(pconf.Session, fmt.Sprintf("/nodes/%s/network", node), "iface", name)
+}
+
+// reloadNetwork applies the staged network changes of the node. This needs
+// ifupdown2 on the node.
+func reloadNetwork(pconf *providerConfiguration, node string, timeout time.Duration) error {
+	err := runTask(pconf, "PUT", fmt.Sprintf("/nodes/%s/network", node), nil, timeout)
+	if err != nil {
+		return fmt.Errorf("Applying the network changes of node %s failed, they are still pending: %v", node, err)
+	}
+	return nil
+}
+
+func networkId(node string, name string) string {
+	return fmt.Sprintf("%s/network/%s", node, name)
+}
+
+var rxNetworkId = regexp.MustCompile(`^([^/]+)/network/([^/]+)$`)
+
+func parseNetworkId(id string) (node string, name string, err error) {
+	match := rxNetworkId.FindStringSubmatch(id)
+	if match == nil {
+		return "", "", fmt.Errorf("Invalid resource format: %s. Must be node/network/name", id)
+	}
+	return match[1], match[2], nil
+}
@@ -0,0 +1,154 @@
+package proxmox
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
+)
+
+var rxPoolId = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+func resourcePool() *schema.Resource {
+	return &schema.Resource{
+		Create: resourcePoolCreate,
+		Read:   resourcePoolRead,
+		Update: resourcePoolUpdate,
+		Delete: resourcePoolDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"poolid": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				ValidateFunc: validation.StringMatch(rxPoolId, "must only contain letters, digits, _, . and -"),
+			},
+			"comment": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+		},
+	}
+}
+
+func resourcePoolCreate(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	poolID := d.Get("poolid").(string)
+	params := map[string]interface{}{
+		"poolid": poolID,
+	}
+	if comment := d.Get("comment").(string); comment != "" {
+		params["comment"] = comment
+	}
+	err := pconf.Retry.Do(func() error {
+		_, err := pconf.Session.Post("/pools", params)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	d.SetId(poolID)
+	return resourcePoolRead(d, meta)
+}
+
+func resourcePoolRead(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	pool, err := getPool(pconf, d.Id())
+	if err != nil {
+		return err
+	}
+	if pool == nil {
+		log.Printf("[WARN] pool %s is gone, removing it from state", d.Id())
+		d.SetId("")
+		return nil
+	}
+	d.Set("poolid", d.Id())
+	comment, _ := pool["comment"].(string)
+	d.Set("comment", comment)
+	return nil
+}
+
+func resourcePoolUpdate(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	params := map[string]interface{}{
+		"comment": d.Get("comment").(string),
+	}
+	err := pconf.Retry.Do(func() error {
+		_, err := pconf.Session.Put(poolPath(d.Id()), params)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return resourcePoolRead(d, meta)
+}
+
+func resourcePoolDelete(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	pool, err := getPool(pconf, d.Id())
+	if err != nil {
+		return err
+	}
+	if pool == nil {
+		log.Printf("[DEBUG] pool %s is already gone", d.Id())
+		return nil
+	}
+	// Proxmox only deletes empty pools, the error lists what is left.
+	return pconf.Retry.Do(func() error {
+		_, err := pconf.Session.Delete(poolPath(d.Id()), nil)
+		return err
+	})
+}
+
+// getPool returns the pool from the list of pools, or nil when there is no
+// such pool. The list does not include the members.
+func getPool(pconf *providerConfiguration, poolID string) (map[string]interface{}, error) {
+	return apiListItem(pconf.Session, "/pools", "poolid", poolID)
+}
+
+// moveVmPool moves a VM or container from oldPool to newPool, either of which
+// may be empty. The pool is not part of the guest config: updateVmConfig moves
+// VMs itself, but ConfigLxc.UpdateConfig does not move containers.
+func moveVmPool(pconf *providerConfiguration, vmID int, oldPool string, newPool string) error {
+	if oldPool == newPool {
+		return nil
+	}
+	if oldPool != "" {
+		log.Printf("[DEBUG] removing VM %d from pool %s", vmID, oldPool)
+		params := map[string]interface{}{
+			"vms":    vmID,
+			"delete": true,
+		}
+		err := pconf.Retry.Do(func() error {
+			_, err := pconf.Session.Put(poolPath(oldPool), params)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if newPool != "" {
+		log.Printf("[DEBUG] adding VM %d to pool %s", vmID, newPool)
+		params := map[string]interface{}{
+			"vms": vmID,
+		}
+		err := pconf.Retry.Do(func() error {
+			_, err := pconf.Session.Put(poolPath(newPool), params)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func poolPath(poolID string) string {
+	return fmt.Sprintf("/pools/%s", url.PathEscape(poolID))
+}
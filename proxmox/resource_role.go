@@ -0,0 +1,123 @@
+package proxmox
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
+)
+
+var rxPrivilege = regexp.MustCompile(`^[A-Z][a-zA-Z]*(\.[A-Z][a-zA-Z]*)+$`)
+
+func resourceRole() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceRoleCreate,
+		Read:   resourceRoleRead,
+		Update: resourceRoleUpdate,
+		Delete: resourceRoleDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"roleid": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				ValidateFunc: validateAccessId,
+			},
+			"privileges": {
+				Type:     schema.TypeSet,
+				Required: true,
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validation.StringMatch(rxPrivilege, "must be a privilege, like VM.PowerMgmt"),
+				},
+			},
+		},
+	}
+}
+
+func resourceRoleCreate(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	roleID := d.Get("roleid").(string)
+	params := map[string]interface{}{
+		"roleid": roleID,
+		"privs":  rolePrivileges(d),
+	}
+	err := pconf.Retry.Do(func() error {
+		_, err := pconf.Session.Post("/access/roles", params)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	d.SetId(roleID)
+	return resourceRoleRead(d, meta)
+}
+
+func resourceRoleRead(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	role, err := apiListItem(pconf.Session, "/access/roles", "roleid", d.Id())
+	if err != nil {
+		return err
+	}
+	if role == nil {
+		log.Printf("[WARN] role %s is gone, removing it from state", d.Id())
+		d.SetId("")
+		return nil
+	}
+	d.Set("roleid", d.Id())
+	d.Set("privileges", apiStringList(role["privs"]))
+	return nil
+}
+
+func resourceRoleUpdate(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	// Without append, the privileges replace the ones of the role.
+	params := map[string]interface{}{
+		"privs": rolePrivileges(d),
+	}
+	err := pconf.Retry.Do(func() error {
+		_, err := pconf.Session.Put(rolePath(d.Id()), params)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return resourceRoleRead(d, meta)
+}
+
+func resourceRoleDelete(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	role, err := apiListItem(pconf.Session, "/access/roles", "roleid", d.Id())
+	if err != nil {
+		return err
+	}
+	if role == nil {
+		log.Printf("[DEBUG] role %s is already gone", d.Id())
+		return nil
+	}
+	return pconf.Retry.Do(func() error {
+		_, err := pconf.Session.Delete(rolePath(d.Id()), nil)
+		return err
+	})
+}
+
+func rolePrivileges(d *schema.ResourceData) string {
+	var privileges []string
+	for _, privilege := range d.Get("privileges").(*schema.Set).List() {
+		privileges = append(privileges, privilege.(string))
+	}
+	sort.Strings(privileges)
+	return strings.Join(privileges, ",")
+}
+
+func rolePath(roleID string) string {
+	return fmt.Sprintf("/access/roles/%s", url.PathEscape(roleID))
+}
@@ -0,0 +1,352 @@
+package proxmox
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
+)
+
+// Proxmox only lists files with these extensions as ISO images.
+var rxIsoFilename = regexp.MustCompile(`^[^/\\]+\.(iso|img)$`)
+
+func resourceStorageIso() *schema.Resource {
+	return &schema.Resource{
+		Create:        resourceStorageIsoCreate,
+		Read:          resourceStorageIsoRead,
+		Update:        resourceStorageIsoUpdate,
+		Delete:        resourceStorageIsoDelete,
+		CustomizeDiff: resourceStorageIsoCustomizeDiff,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultTimeout),
+			Delete: schema.DefaultTimeout(defaultTimeout),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"target_node": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"storage": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"filename": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				ForceNew:     true,
+				ValidateFunc: validation.StringMatch(rxIsoFilename, "must be a file name ending with .iso or .img"),
+			},
+			"source": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				ExactlyOneOf: []string{"source", "url"},
+			},
+			"url": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				ExactlyOneOf: []string{"source", "url"},
+				ValidateFunc: validation.IsURLWithHTTPorHTTPS,
+			},
+			"checksum": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				ValidateFunc: validation.StringMatch(regexp.MustCompile(`^[0-9a-fA-F]+$`), "must be a hexadecimal digest"),
+			},
+			"checksum_algorithm": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				Default:      "sha256",
+				ValidateFunc: validation.StringInSlice([]string{"sha256", "sha512"}, false),
+			},
+			"overwrite": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"volid": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"size": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func resourceStorageIsoCreate(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	pmParallelBegin(pconf)
+	defer pmParallelEnd(pconf)
+	deadline := time.Now().Add(d.Timeout(schema.TimeoutCreate))
+
+	node := d.Get("target_node").(string)
+	storage := d.Get("storage").(string)
+	source := d.Get("source").(string)
+	sourceUrl := d.Get("url").(string)
+	filename := d.Get("filename").(string)
+	if filename == "" {
+		filename = isoFilename(source, sourceUrl)
+		if !rxIsoFilename.MatchString(filename) {
+			return fmt.Errorf("Cannot use %q as ISO file name, set filename to a name ending with .iso or .img", filename)
+		}
+	}
+	volid := isoVolid(storage, filename)
+
+	// Proxmox either refuses or silently replaces an existing file, depending
+	// on the version, so only replace one on request.
+	existing, err := storageIsoContent(pconf, node, storage, volid)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		if !d.Get("overwrite").(bool) {
+			return fmt.Errorf("ISO image %s already exists on node %s, set overwrite to replace it", volid, node)
+		}
+		log.Printf("[INFO] replacing existing ISO image %s on node %s", volid, node)
+		err = deleteStorageContent(pconf, node, storage, volid, time.Until(deadline))
+		if err != nil {
+			return err
+		}
+	}
+
+	storagePath := fmt.Sprintf("/nodes/%s/storage/%s", node, url.PathEscape(storage))
+	checksum := strings.ToLower(d.Get("checksum").(string))
+	algorithm := d.Get("checksum_algorithm").(string)
+	if sourceUrl != "" {
+		// Proxmox verifies the checksum once downloaded.
+		params := map[string]interface{}{
+			"content":  "iso",
+			"filename": filename,
+			"url":      sourceUrl,
+		}
+		if checksum != "" {
+			params["checksum"] = checksum
+			params["checksum-algorithm"] = algorithm
+		}
+		err = runTask(pconf, "POST", storagePath+"/download-url", params, time.Until(deadline))
+	} else {
+		err = uploadIso(pconf, storagePath+"/upload", source, filename, checksum, algorithm, time.Until(deadline))
+	}
+	if err != nil {
+		return err
+	}
+
+	content, err := storageIsoContent(pconf, node, storage, volid)
+	if err != nil {
+		return err
+	}
+	if content == nil {
+		return fmt.Errorf("ISO image %s is missing on node %s after uploading it", volid, node)
+	}
+	d.SetId(storageIsoId(node, volid))
+	d.Set("filename", filename)
+	d.Set("volid", volid)
+	d.Set("size", content.Size)
+	return nil
+}
+
+func resourceStorageIsoRead(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	pmParallelBegin(pconf)
+	defer pmParallelEnd(pconf)
+
+	node, volid, err := parseStorageIsoId(d.Id())
+	if err != nil {
+		return err
+	}
+	storage := strings.SplitN(volid, ":", 2)[0]
+	content, err := storageIsoContent(pconf, node, storage, volid)
+	if err != nil {
+		return err
+	}
+	if content == nil {
+		log.Printf("[WARN] ISO image %s on node %s is gone, removing it from state", volid, node)
+		d.SetId("")
+		return nil
+	}
+	d.Set("target_node", node)
+	d.Set("storage", storage)
+	d.Set("filename", path.Base(strings.SplitN(volid, ":", 2)[1]))
+	d.Set("volid", volid)
+	// A different size means the file was replaced outside of Terraform. It
+	// stays in state with size -1, see resourceStorageIsoCustomizeDiff.
+	if size := d.Get("size").(int); size != 0 && int64(size) != content.Size {
+		log.Printf("[WARN] ISO image %s on node %s changed size from %d to %d bytes, it will be replaced", volid, node, size, content.Size)
+		d.Set("size", -1)
+		return nil
+	}
+	d.Set("size", content.Size)
+	return nil
+}
+
+// resourceStorageIsoCustomizeDiff replaces an image which Read found replaced
+// outside of Terraform, so the file is deleted before the configured image is
+// uploaded again.
+func resourceStorageIsoCustomizeDiff(d *schema.ResourceDiff, meta interface{}) error {
+	if d.Id() == "" || d.Get("size").(int) >= 0 {
+		return nil
+	}
+	err := d.SetNewComputed("size")
+	if err != nil {
+		return err
+	}
+	return d.ForceNew("size")
+}
+
+// resourceStorageIsoUpdate only stores overwrite, every other argument
+// replaces the image.
+func resourceStorageIsoUpdate(d *schema.ResourceData, meta interface{}) error {
+	return resourceStorageIsoRead(d, meta)
+}
+
+func resourceStorageIsoDelete(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	pmParallelBegin(pconf)
+	defer pmParallelEnd(pconf)
+
+	node, volid, err := parseStorageIsoId(d.Id())
+	if err != nil {
+		return err
+	}
+	storage := strings.SplitN(volid, ":", 2)[0]
+	content, err := storageIsoContent(pconf, node, storage, volid)
+	if err != nil {
+		return err
+	}
+	if content == nil {
+		log.Printf("[DEBUG] ISO image %s on node %s is already gone", volid, node)
+		return nil
+	}
+	return deleteStorageContent(pconf, node, storage, volid, d.Timeout(schema.TimeoutDelete))
+}
+
+// storageContent is a volume listed by the storage content endpoint.
+type storageContent struct {
+	Volid string
+	Size  int64
+}
+
+// storageIsoContent looks up the ISO image volid on the storage. It returns
+// nil when there is no such image.
+func storageIsoContent(pconf *providerConfiguration, node string, storage string, volid string) (*storageContent, error) {
+	contentPath := fmt.Sprintf("/nodes/%s/storage/%s/content", node, url.PathEscape(storage))
+	resp, err := pconf.Session.Get(contentPath, map[string]interface{}{"content": "iso"})
+	if err != nil {
+		return nil, err
+	}
+	volumes, _ := resp["data"].([]interface{})
+	for _, volume := range volumes {
+		volume, _ := volume.(map[string]interface{})
+		if volume["volid"] != volid {
+			continue
+		}
+		size, _ := volume["size"].(float64)
+		return &storageContent{Volid: volid, Size: int64(size)}, nil
+	}
+	return nil, nil
+}
+
+// deleteStorageContent removes a volume from the storage. Recent Proxmox
+// versions do so in a task, older ones synchronously.
+func deleteStorageContent(pconf *providerConfiguration, node string, storage string, volid string, timeout time.Duration) error {
+	volumePath := fmt.Sprintf("/nodes/%s/storage/%s/content/%s", node, url.PathEscape(storage), url.PathEscape(volid))
+	return runTask(pconf, "DELETE", volumePath, nil, timeout)
+}
+
+// uploadIso verifies the checksum of the local file, if any, and uploads it.
+func uploadIso(pconf *providerConfiguration, uploadPath string, source string, filename string, checksum string, algorithm string, timeout time.Duration) error {
+	file, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if checksum != "" {
+		digest, err := fileDigest(file, algorithm)
+		if err != nil {
+			return err
+		}
+		if digest != checksum {
+			return fmt.Errorf("%s checksum of %s is %s, expected %s", algorithm, source, digest, checksum)
+		}
+	}
+	return pconf.Retry.Do(func() error {
+		resp, err := pconf.Session.Upload(uploadPath, map[string]interface{}{"content": "iso"}, "filename", filename, file)
+		if err != nil {
+			return err
+		}
+		upid, _ := resp["data"].(string)
+		return waitForTask(pconf.Session, upid, timeout)
+	})
+}
+
+func fileDigest(file *os.File, algorithm string) (string, error) {
+	var h hash.Hash
+	switch algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("Unsupported checksum algorithm %s", algorithm)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// isoFilename derives the file name from the source file or URL.
+func isoFilename(source string, sourceUrl string) string {
+	if source != "" {
+		return filepath.Base(source)
+	}
+	parsed, err := url.Parse(sourceUrl)
+	if err != nil {
+		return ""
+	}
+	return path.Base(parsed.Path)
+}
+
+func isoVolid(storage string, filename string) string {
+	return fmt.Sprintf("%s:iso/%s", storage, filename)
+}
+
+func storageIsoId(node string, volid string) string {
+	return fmt.Sprintf("%s/%s", node, volid)
+}
+
+var rxStorageIsoId = regexp.MustCompile(`^([^/]+)/([^/:]+:iso/[^/]+)$`)
+
+func parseStorageIsoId(id string) (node string, volid string, err error) {
+	match := rxStorageIsoId.FindStringSubmatch(id)
+	if match == nil {
+		return "", "", fmt.Errorf("Invalid resource format: %s. Must be node/storage:iso/filename", id)
+	}
+	return match[1], match[2], nil
+}
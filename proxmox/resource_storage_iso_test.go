@@ -0,0 +1,63 @@
+package proxmox
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+)
+
+func TestResourceStorageIsoReadReplaced(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		expected    int
+		requiresNew bool
+	}{
+		{"unchanged", 1048576, 1048576, false},
+		// The file was replaced outside of Terraform, the image is kept in
+		// state so it is deleted before it is created again.
+		{"size changed", 2097152, -1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeApiServer()
+			defer server.Close()
+			server.handle("GET /nodes/pve/storage/local/content", func(w http.ResponseWriter, r *http.Request) {
+				writeApiData(w, []interface{}{
+					map[string]interface{}{"volid": "local:iso/debian.iso", "size": test.size},
+				})
+			})
+			pconf := server.config()
+			pconf.MaxParallel = 1
+			pconf.Mutex = &sync.Mutex{}
+			pconf.Cond = sync.NewCond(pconf.Mutex)
+
+			raw := map[string]interface{}{
+				"target_node": "pve",
+				"storage":     "local",
+				"url":         "https://example.com/debian.iso",
+			}
+			resource := resourceStorageIso()
+			d := schema.TestResourceDataRaw(t, resource.Schema, raw)
+			d.SetId("pve/local:iso/debian.iso")
+			d.Set("size", 1048576)
+
+			if err := resourceStorageIsoRead(d, pconf); err != nil {
+				t.Fatal(err)
+			}
+			if d.Id() == "" || d.Get("size").(int) != test.expected {
+				t.Fatalf("expected the image to stay with size %d, got id %q and size %d", test.expected, d.Id(), d.Get("size"))
+			}
+			diff, err := resource.Diff(d.State(), terraform.NewResourceConfigRaw(raw), pconf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if requiresNew := diff != nil && diff.RequiresNew(); requiresNew != test.requiresNew {
+				t.Errorf("expected replacement %v, got diff %v", test.requiresNew, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package proxmox
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
+)
+
+func resourceUser() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceUserCreate,
+		Read:   resourceUserRead,
+		Update: resourceUserUpdate,
+		Delete: resourceUserDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"userid": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				ValidateFunc: validateUserId,
+			},
+			"password": {
+				Type:      schema.TypeString,
+				Optional:  true,
+				Sensitive: true,
+			},
+			"comment": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"email": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"firstname": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"lastname": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"enable": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+			"expire": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"groups": {
+				Type:     schema.TypeSet,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validateAccessId,
+				},
+			},
+			"keys": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+		},
+	}
+}
+
+func resourceUserCreate(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	userID := d.Get("userid").(string)
+	params := userParams(d)
+	for key, value := range params {
+		if value == "" {
+			delete(params, key)
+		}
+	}
+	params["userid"] = userID
+	// Only users of the pve realm have a password in Proxmox.
+	if password := d.Get("password").(string); password != "" {
+		params["password"] = password
+	}
+	err := pconf.Retry.Do(func() error {
+		_, err := pconf.Session.Post("/access/users", params)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	d.SetId(userID)
+	return resourceUserRead(d, meta)
+}
+
+func resourceUserRead(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	user, err := apiListItem(pconf.Session, "/access/users", "userid", d.Id())
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		log.Printf("[WARN] user %s is gone, removing it from state", d.Id())
+		d.SetId("")
+		return nil
+	}
+	// The list leaves out the groups of older Proxmox versions.
+	resp, err := pconf.Session.Get(userPath(d.Id()), nil)
+	if err != nil {
+		return err
+	}
+	user, _ = resp["data"].(map[string]interface{})
+
+	d.Set("userid", d.Id())
+	d.Set("comment", apiString(user["comment"]))
+	d.Set("email", apiString(user["email"]))
+	d.Set("firstname", apiString(user["firstname"]))
+	d.Set("lastname", apiString(user["lastname"]))
+	d.Set("enable", apiInt(user["enable"]) == 1)
+	d.Set("expire", apiInt(user["expire"]))
+	d.Set("keys", apiString(user["keys"]))
+	d.Set("groups", apiStringList(user["groups"]))
+	return nil
+}
+
+func resourceUserUpdate(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	err := pconf.Retry.Do(func() error {
+		_, err := pconf.Session.Put(userPath(d.Id()), userParams(d))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if d.HasChange("password") {
+		// Proxmox only lets users with the Realm.AllocateUser privilege, like
+		// root@pam, change the password of another user.
+		params := map[string]interface{}{
+			"userid":   d.Id(),
+			"password": d.Get("password").(string),
+		}
+		err = pconf.Retry.Do(func() error {
+			_, err := pconf.Session.Put("/access/password", params)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return resourceUserRead(d, meta)
+}
+
+func resourceUserDelete(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	user, err := apiListItem(pconf.Session, "/access/users", "userid", d.Id())
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		log.Printf("[DEBUG] user %s is already gone", d.Id())
+		return nil
+	}
+	return pconf.Retry.Do(func() error {
+		_, err := pconf.Session.Delete(userPath(d.Id()), nil)
+		return err
+	})
+}
+
+// userParams returns the parameters of the user which can be both created and
+// updated. Empty strings clear a value.
+func userParams(d *schema.ResourceData) map[string]interface{} {
+	var groups []string
+	for _, group := range d.Get("groups").(*schema.Set).List() {
+		groups = append(groups, group.(string))
+	}
+	sort.Strings(groups)
+	return map[string]interface{}{
+		"comment":   d.Get("comment").(string),
+		"email":     d.Get("email").(string),
+		"firstname": d.Get("firstname").(string),
+		"lastname":  d.Get("lastname").(string),
+		"enable":    d.Get("enable").(bool),
+		"expire":    d.Get("expire").(int),
+		"keys":      d.Get("keys").(string),
+		"groups":    strings.Join(groups, ","),
+	}
+}
+
+func userPath(userID string) string {
+	return fmt.Sprintf("/access/users/%s", url.PathEscape(userID))
+}
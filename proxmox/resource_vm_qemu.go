@@ -3,9 +3,9 @@ package proxmox
 import (
 	"fmt"
 	"log"
-	"math"
 	"path"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -22,9 +22,15 @@ func resourceVmQemu() *schema.Resource {
 		Update: resourceVmQemuUpdate,
 		Delete: resourceVmQemuDelete,
 		Importer: &schema.ResourceImporter{
-			State: schema.ImportStatePassthrough,
+			State: importIpconfigs,
 		},
-		Schema: resourceQemuSchema,
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultTimeout),
+			Update: schema.DefaultTimeout(defaultTimeout),
+			Delete: schema.DefaultTimeout(defaultTimeout),
+		},
+		CustomizeDiff: resourceVmQemuCustomizeDiff,
+		Schema:        resourceQemuSchema,
 	}
 }
 
@@ -34,9 +40,15 @@ func resourceVmQemuCreate(d *schema.ResourceData, meta interface{}) error {
 	pconf := meta.(*providerConfiguration)
 	pmParallelBegin(pconf)
 	defer pmParallelEnd(pconf)
+	// All tasks of the create share its timeout.
+	deadline := time.Now().Add(d.Timeout(schema.TimeoutCreate))
 
 	client := pconf.Client
 	config := expandVmQemu(d)
+	ipconfigs, err := expandIpconfigs(d)
+	if err != nil {
+		return err
+	}
 	vmName := config.Name
 	qemuDisks := config.QemuDisks
 	log.Print("[DEBUG] checking for duplicate name")
@@ -45,20 +57,28 @@ func resourceVmQemuCreate(d *schema.ResourceData, meta interface{}) error {
 	forceCreate := d.Get("force_create").(bool)
 	targetNode := d.Get("target_node").(string)
 	pool := d.Get("pool").(string)
+	vmID := d.Get("vmid").(int)
 
 	if dupVmr != nil && forceCreate {
 		return fmt.Errorf("Duplicate VM name (%s) with vmId: %d. Set force_create=false to recycle", vmName, dupVmr.VmId())
 	} else if dupVmr != nil && dupVmr.Node() != targetNode {
 		return fmt.Errorf("Duplicate VM name (%s) with vmId: %d on different target_node=%s", vmName, dupVmr.VmId(), dupVmr.Node())
+	} else if dupVmr != nil && vmID != 0 && dupVmr.VmId() != vmID {
+		return fmt.Errorf("Duplicate VM name (%s) with vmId: %d instead of vmid=%d", vmName, dupVmr.VmId(), vmID)
 	}
 
 	vmr := dupVmr
 
 	if vmr == nil {
-		// get unique id
-		nextid, err := nextVmId(pconf)
-		if err != nil {
-			return err
+		// get unique id, unless one was given
+		nextid := vmID
+		if nextid == 0 {
+			var err error
+			nextid, err = nextVmId(pconf)
+			if err != nil {
+				return err
+			}
+			defer releaseVmId(pconf, nextid)
 		}
 		vmr = pxapi.NewVmRef(nextid)
 
@@ -68,87 +88,63 @@ func resourceVmQemuCreate(d *schema.ResourceData, meta interface{}) error {
 			vmr.SetPool(pool)
 		}
 
-		// check if ISO or clone
-		if d.Get("clone").(string) != "" {
-			fullClone := 1
-			if !d.Get("full_clone").(bool) {
-				fullClone = 0
-			}
-			config.FullClone = &fullClone
-
-			sourceVmr, err := client.GetVmRefByName(d.Get("clone").(string))
-			if err != nil {
-				return err
-			}
-			log.Print("[DEBUG] cloning VM")
-			err = config.CloneVm(sourceVmr, vmr, client)
-
-			if err != nil {
-				return err
-			}
-
-			err = config.UpdateConfig(vmr, client)
-			if err != nil {
-				// Set the id because when update config fail the vm is still created
-				d.SetId(resourceId(targetNode, "qemu", vmr.VmId()))
-				return err
-			}
-
-			// give sometime to proxmox to catchup
-			time.Sleep(time.Duration(d.Get("clone_wait").(int)) * time.Second)
-
-			err = prepareDiskSize(client, vmr, qemuDisks)
-			if err != nil {
-				return err
-			}
+		err = buildVmQemu(pconf, d, config, ipconfigs, vmr, deadline)
+		if err != nil {
+			return err
+		}
+	} else {
+		log.Printf("[DEBUG] recycling VM vmId: %d", vmr.VmId())
 
-		} else if d.Get("iso").(string) != "" {
-			config.QemuIso = d.Get("iso").(string)
-			err := config.CreateVm(vmr, client)
+		running, err := vmIsRunning(pconf, vmr)
+		if err != nil {
+			return err
+		}
+		if running {
+			err = shutdownVm(pconf, d, vmr, time.Until(deadline))
 			if err != nil {
 				return err
 			}
-		} else {
-			return fmt.Errorf("Either clone or iso must be set")
 		}
-	} else {
-		log.Printf("[DEBUG] recycling VM vmId: %d", vmr.VmId())
 
-		client.StopVm(vmr)
+		err = prepareDiskSize(pconf, vmr, qemuDisks, deadline)
+		if err != nil {
+			setVmQemuId(d, targetNode, vmr)
+			return err
+		}
 
-		err := config.UpdateConfig(vmr, client)
+		err = updateVmConfig(pconf, config, vmr, time.Until(deadline))
 		if err != nil {
 			// Set the id because when update config fail the vm is still created
-			d.SetId(resourceId(targetNode, "qemu", vmr.VmId()))
+			setVmQemuId(d, targetNode, vmr)
 			return err
 		}
-
-		// give sometime to proxmox to catchup
-		time.Sleep(5 * time.Second)
-
-		err = prepareDiskSize(client, vmr, qemuDisks)
+		err = updateIpconfigs(pconf, vmr, ipconfigs, time.Until(deadline))
 		if err != nil {
+			setVmQemuId(d, targetNode, vmr)
 			return err
 		}
 	}
-	d.SetId(resourceId(targetNode, "qemu", vmr.VmId()))
-
-	// give sometime to proxmox to catchup
-	time.Sleep(15 * time.Second)
+	setVmQemuId(d, targetNode, vmr)
 
 	log.Print("[DEBUG] starting VM")
-	_, err := client.StartVm(vmr)
+	err = vmStatusChange(pconf, vmr, "start", time.Until(deadline))
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if d.Get("agent").(int) == 1 {
+		waitForGuestAddress(pconf, vmr, deadline)
+	}
+	readGuestAddresses(pconf, d, vmr)
+
+	return preprovision(pconf, d, vmr, deadline)
 }
 
 func resourceVmQemuUpdate(d *schema.ResourceData, meta interface{}) error {
 	pconf := meta.(*providerConfiguration)
 	pmParallelBegin(pconf)
 	defer pmParallelEnd(pconf)
+	deadline := time.Now().Add(d.Timeout(schema.TimeoutUpdate))
 
 	client := pconf.Client
 	_, _, vmID, err := parseResourceId(d.Id())
@@ -163,7 +159,7 @@ func resourceVmQemuUpdate(d *schema.ResourceData, meta interface{}) error {
 
 	d.Partial(true)
 	if d.HasChange("target_node") {
-		_, err := client.MigrateNode(vmr, d.Get("target_node").(string), true)
+		err := migrateVm(pconf, vmr, d.Get("target_node").(string), true, time.Until(deadline))
 		if err != nil {
 			return err
 		}
@@ -173,27 +169,44 @@ func resourceVmQemuUpdate(d *schema.ResourceData, meta interface{}) error {
 	d.Partial(false)
 
 	config := expandVmQemu(d)
+	ipconfigs, err := expandIpconfigs(d)
+	if err != nil {
+		return err
+	}
 
-	err = config.UpdateConfig(vmr, client)
+	// updateVmConfig only sets the devices of the config, removed ones would
+	// stay on the VM.
+	err = deleteRemovedDevices(pconf, d, vmr, deadline)
 	if err != nil {
 		return err
 	}
 
-	// give sometime to proxmox to catchup
-	time.Sleep(5 * time.Second)
+	// Resize before updating the config, which also writes the size to the
+	// config without resizing the disk.
+	err = prepareDiskSize(pconf, vmr, config.QemuDisks, deadline)
+	if err != nil {
+		return err
+	}
 
-	prepareDiskSize(client, vmr, config.QemuDisks)
+	err = updateVmConfig(pconf, config, vmr, time.Until(deadline))
+	if err != nil {
+		return err
+	}
 
-	// TODO: poll proxmox with timeout
-	// give sometime to proxmox to catchup
-	time.Sleep(15 * time.Second)
+	if d.HasChange("ipconfig") {
+		err = updateIpconfigs(pconf, vmr, ipconfigs, time.Until(deadline))
+		if err != nil {
+			return err
+		}
+	}
 
 	// Start VM only if it wasn't running.
 	vmState, err := client.GetVmState(vmr)
 	if err == nil && vmState["status"] == "stopped" {
 		log.Print("[DEBUG] starting VM")
-		_, err = client.StartVm(vmr)
-	} else if err != nil {
+		err = vmStatusChange(pconf, vmr, "start", time.Until(deadline))
+	}
+	if err != nil {
 		return err
 	}
 
@@ -222,6 +235,14 @@ func resourceVmQemuRead(d *schema.ResourceData, meta interface{}) error {
 	}
 
 	flattenVmQemu(vmr, config, d)
+	if readsIpconfigBlocks(d) {
+		vmConfig, err := client.GetVmConfig(vmr)
+		if err != nil {
+			return err
+		}
+		d.Set("ipconfig", flattenIpconfigs(vmConfig))
+	}
+	readGuestAddresses(pconf, d, vmr)
 
 	return nil
 }
@@ -231,79 +252,265 @@ func resourceVmQemuDelete(d *schema.ResourceData, meta interface{}) error {
 	pmParallelBegin(pconf)
 	defer pmParallelEnd(pconf)
 
-	client := pconf.Client
+	deadline := time.Now().Add(d.Timeout(schema.TimeoutDelete))
+
 	vmId, _ := strconv.Atoi(path.Base(d.Id()))
 	vmr := pxapi.NewVmRef(vmId)
-	_, err := client.StopVm(vmr)
+	running, err := vmIsRunning(pconf, vmr)
 	if err != nil {
 		return err
 	}
-	// give sometime to proxmox to catchup
-	time.Sleep(2 * time.Second)
-	_, err = client.DeleteVm(vmr)
-	return err
+	if running {
+		err = shutdownVm(pconf, d, vmr, time.Until(deadline))
+		if err != nil {
+			return err
+		}
+	}
+	return deleteVm(pconf, vmr, false, time.Until(deadline))
 }
 
-// Increase disk size if original disk was smaller than new disk.
+// buildVmQemu creates the VM vmr by cloning clone or clone_vmid, or from iso,
+// and applies config to it. Once the VM exists, the id is set before
+// returning errors, so a failed step does not leave the VM unmanaged.
+func buildVmQemu(pconf *providerConfiguration, d *schema.ResourceData, config pxapi.ConfigQemu, ipconfigs map[string]interface{}, vmr *pxapi.VmRef, deadline time.Time) error {
+	targetNode := d.Get("target_node").(string)
+	qemuDisks := config.QemuDisks
+
+	if d.Get("clone").(string) != "" || d.Get("clone_vmid").(int) != 0 {
+		fullClone := 1
+		if !d.Get("full_clone").(bool) {
+			fullClone = 0
+		}
+		config.FullClone = &fullClone
+
+		sourceVmr, err := cloneSourceVmr(pconf, d)
+		if err != nil {
+			return err
+		}
+		log.Print("[DEBUG] cloning VM")
+		err = cloneVm(pconf, config, sourceVmr, vmr, time.Until(deadline))
+		if err != nil {
+			return err
+		}
+
+		// Resize before updating the config, which also writes the size to the
+		// config without resizing the disk.
+		err = prepareDiskSize(pconf, vmr, qemuDisks, deadline)
+		if err != nil {
+			setVmQemuId(d, targetNode, vmr)
+			return err
+		}
+
+		err = updateVmConfig(pconf, config, vmr, time.Until(deadline))
+		if err != nil {
+			// Set the id because when update config fail the vm is still created
+			setVmQemuId(d, targetNode, vmr)
+			return err
+		}
+	} else if d.Get("iso").(string) != "" {
+		config.QemuIso = d.Get("iso").(string)
+		err := createVm(pconf, config, vmr, time.Until(deadline))
+		if err != nil {
+			return err
+		}
+	} else {
+		return fmt.Errorf("Either clone, clone_vmid or iso must be set")
+	}
+	err := updateIpconfigs(pconf, vmr, ipconfigs, time.Until(deadline))
+	if err != nil {
+		setVmQemuId(d, targetNode, vmr)
+		return err
+	}
+	return nil
+}
+
+// setVmQemuId sets the id of the VM, and its VMID right away, as one taken
+// from pm_vmid_range is only known once the VM is created.
+func setVmQemuId(d *schema.ResourceData, targetNode string, vmr *pxapi.VmRef) {
+	d.SetId(resourceId(targetNode, "qemu", vmr.VmId()))
+	d.Set("vmid", vmr.VmId())
+}
+
+// cloneSourceVmr looks up the VM to clone, by clone_vmid or else by name.
+func cloneSourceVmr(pconf *providerConfiguration, d *schema.ResourceData) (*pxapi.VmRef, error) {
+	if vmID := d.Get("clone_vmid").(int); vmID != 0 {
+		sourceVmr := pxapi.NewVmRef(vmID)
+		_, err := pconf.Client.GetVmInfo(sourceVmr)
+		if err != nil {
+			return nil, err
+		}
+		return sourceVmr, nil
+	}
+	return pconf.Client.GetVmRefByName(d.Get("clone").(string))
+}
+
+// Seconds a guest gets to shut down before it is stopped.
+const defaultShutdownTimeout = 60
+
+// shutdownVm shuts a running VM or container down, according to its
+// shutdown_timeout and force_stop arguments.
+func shutdownVm(pconf *providerConfiguration, d *schema.ResourceData, vmr *pxapi.VmRef, timeout time.Duration) error {
+	shutdownTimeout := time.Duration(d.Get("shutdown_timeout").(int)) * time.Second
+	forceStop := d.Get("force_stop").(bool)
+	log.Printf("[DEBUG] shutting down VM %d, force_stop: %t", vmr.VmId(), forceStop)
+	return vmShutdown(pconf, vmr, shutdownTimeout, forceStop, timeout)
+}
+
+// resourceVmQemuCustomizeDiff rejects disk ids beyond their bus and shrinking
+// a disk, which Proxmox cannot do. With allow_disk_recreate, the VM is
+// recreated instead.
+func resourceVmQemuCustomizeDiff(d *schema.ResourceDiff, meta interface{}) error {
+	if err := validateDiskIds(d.Get("disk").(*schema.Set)); err != nil {
+		return err
+	}
+	if d.Id() == "" || !d.HasChange("disk") {
+		return nil
+	}
+	oldDisks, newDisks := d.GetChange("disk")
+	oldDisksMap := expandDevices(oldDisks.(*schema.Set))
+	for diskID, newDisk := range expandDevices(newDisks.(*schema.Set)) {
+		oldDisk, ok := oldDisksMap[diskID]
+		if !ok {
+			continue
+		}
+		oldSize, err := parseDiskSize(oldDisk["size"].(string))
+		if err != nil {
+			continue
+		}
+		// Unknown or invalid sizes are left to validation and apply.
+		newSize, err := parseDiskSize(newDisk["size"].(string))
+		if err != nil || newSize >= oldSize {
+			continue
+		}
+		if d.Get("allow_disk_recreate").(bool) {
+			log.Printf("[DEBUG] disk %d shrinks from %s to %s, recreating the VM", diskID, oldSize, newSize)
+			return d.ForceNew("disk")
+		}
+		return fmt.Errorf("Disk %d cannot be shrunk from %s to %s. Set allow_disk_recreate to recreate the VM instead", diskID, oldSize, newSize)
+	}
+	return nil
+}
+
+// deleteRemovedDevices deletes the disks, networks and serials which were
+// removed from the config. Removed disks are kept as unused disks or destroyed,
+// according to disk_detach_policy.
+func deleteRemovedDevices(pconf *providerConfiguration, d *schema.ResourceData, vmr *pxapi.VmRef, deadline time.Time) error {
+	var disks, keys []string
+	if d.HasChange("disk") {
+		oldDisks, newDisks := d.GetChange("disk")
+		disks = removedDiskKeys(oldDisks.(*schema.Set), newDisks.(*schema.Set))
+		keys = append(keys, disks...)
+	}
+	if d.HasChange("network") {
+		oldNetworks, newNetworks := d.GetChange("network")
+		keys = append(keys, removedDeviceKeys("net", oldNetworks.(*schema.Set), newNetworks.(*schema.Set))...)
+	}
+	if d.HasChange("serial") {
+		oldSerials, newSerials := d.GetChange("serial")
+		keys = append(keys, removedDeviceKeys("serial", oldSerials.(*schema.Set), newSerials.(*schema.Set))...)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// The volumes of the disks, to find them back among the unused disks.
+	vmConfig, err := pconf.Client.GetVmConfig(vmr)
+	if err != nil {
+		return err
+	}
+	volumes := map[string]bool{}
+	for _, disk := range disks {
+		if diskConf, ok := vmConfig[disk].(string); ok {
+			volumes[strings.Split(diskConf, ",")[0]] = true
+		}
+	}
+
+	log.Printf("[DEBUG] deleting %s", strings.Join(keys, ", "))
+	err = deleteVmConfigKeys(pconf, vmr, keys, time.Until(deadline))
+	if err != nil {
+		return err
+	}
+	if len(volumes) == 0 || d.Get("disk_detach_policy").(string) != "destroy" {
+		return nil
+	}
+
+	vmConfig, err = pconf.Client.GetVmConfig(vmr)
+	if err != nil {
+		return err
+	}
+	var unused []string
+	for key, value := range vmConfig {
+		if volume, ok := value.(string); ok && strings.HasPrefix(key, "unused") && volumes[volume] {
+			unused = append(unused, key)
+		}
+	}
+	if len(unused) < len(volumes) {
+		// Disks which cannot be hot unplugged are detached when the VM
+		// restarts, only then they can be destroyed.
+		log.Printf("[WARN] %d of the removed disks of VM %d are still attached until it restarts, they are not destroyed", len(volumes)-len(unused), vmr.VmId())
+	}
+	if len(unused) == 0 {
+		return nil
+	}
+	sort.Strings(unused)
+	log.Printf("[DEBUG] destroying %s", strings.Join(unused, ", "))
+	return deleteVmConfigKeys(pconf, vmr, unused, time.Until(deadline))
+}
+
+// removedDiskKeys returns the keys, like virtio1, of the disks of oldSet which
+// are not in newSet. A disk of which the type changed is removed too.
+func removedDiskKeys(oldSet *schema.Set, newSet *schema.Set) []string {
+	newKeys := map[string]bool{}
+	for diskID, disk := range expandDevices(newSet) {
+		newKeys[fmt.Sprintf("%v%d", disk["type"], diskID)] = true
+	}
+	var keys []string
+	for diskID, disk := range expandDevices(oldSet) {
+		if key := fmt.Sprintf("%v%d", disk["type"], diskID); !newKeys[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// prepareDiskSize grows the disks of the VM to their configured size, for
+// example after cloning a template with smaller disks. Disks are never shrunk.
 func prepareDiskSize(
-	client *pxapi.Client,
+	pconf *providerConfiguration,
 	vmr *pxapi.VmRef,
 	diskConfMap pxapi.QemuDevices,
+	deadline time.Time,
 ) error {
-	clonedConfig, err := pxapi.NewConfigQemuFromApi(vmr, client)
+	clonedConfig, err := pxapi.NewConfigQemuFromApi(vmr, pconf.Client)
 	if err != nil {
 		return err
 	}
-	//log.Printf("%s", clonedConfig)
 	for diskID, diskConf := range diskConfMap {
+		clonedDisk, diskExists := clonedConfig.QemuDisks[diskID]
+		if !diskExists {
+			// New disks are created with their size.
+			continue
+		}
 		diskName := fmt.Sprintf("%v%v", diskConf["type"], diskID)
-
-		diskSize := diskSizeGB(diskConf["size"])
-
-		if _, diskExists := clonedConfig.QemuDisks[diskID]; !diskExists {
-			return err
+		diskSize, err := parseDiskSize(diskConf["size"].(string))
+		if err != nil {
+			return fmt.Errorf("Disk %s: %v", diskName, err)
 		}
-
-		clonedDiskSize := diskSizeGB(clonedConfig.QemuDisks[diskID]["size"])
-
+		clonedDiskSize, err := parseApiSize(fmt.Sprint(clonedDisk["size"]))
 		if err != nil {
-			return err
+			return fmt.Errorf("Disk %s: %v", diskName, err)
 		}
 
-		diffSize := int(math.Ceil(diskSize - clonedDiskSize))
 		if diskSize > clonedDiskSize {
-			log.Print("[DEBUG] resizing disk " + diskName)
-			_, err = client.ResizeQemuDisk(vmr, diskName, diffSize)
+			log.Printf("[DEBUG] resizing disk %s from %s to %s", diskName, clonedDiskSize, diskSize)
+			err = resizeVmDisk(pconf, vmr, diskName, diskSize, time.Until(deadline))
 			if err != nil {
 				return err
 			}
+		} else if diskSize < clonedDiskSize {
+			return fmt.Errorf("Disk %s is %s, it cannot be shrunk to %s", diskName, clonedDiskSize, diskSize)
 		}
 	}
 	return nil
 }
-
-func diskSizeGB(dcSize interface{}) float64 {
-	var diskSize float64
-	switch dcSize.(type) {
-	case string:
-		diskString := strings.ToUpper(dcSize.(string))
-		re := regexp.MustCompile("([0-9]+)([A-Z]*)")
-		diskArray := re.FindStringSubmatch(diskString)
-
-		diskSize, _ = strconv.ParseFloat(diskArray[1], 64)
-
-		if len(diskArray) >= 3 {
-			switch diskArray[2] {
-			case "G", "GB":
-				//Nothing to do
-			case "M", "MB":
-				diskSize /= 1000
-			case "K", "KB":
-				diskSize /= 1000000
-			}
-		}
-	case float64:
-		diskSize = dcSize.(float64)
-	}
-	return diskSize
-}
@@ -0,0 +1,251 @@
+package proxmox
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+// Arguments of resourceQemuSchema which only apply to running VMs.
+var qemuTemplateExcludedArguments = map[string]bool{
+	"hastate":              true,
+	"os_type":              true,
+	"os_network_config":    true,
+	"preprovision":         true,
+	"ssh_forward_ip":       true,
+	"ssh_user":             true,
+	"ssh_private_key":      true,
+	"ssh_host":             true,
+	"ssh_port":             true,
+	"default_ipv4_address": true,
+	"default_ipv6_address": true,
+	"network_interfaces":   true,
+	"ci_wait":              true,
+	"shutdown_timeout":     true,
+	"force_stop":           true,
+	"force_create":         true,
+	"clone_wait":           true,
+	"disk_detach_policy":   true,
+	"allow_disk_recreate":  true,
+}
+
+// Arguments a template can change without building it again.
+var qemuTemplateUpdatableArguments = map[string]bool{
+	"name": true,
+	"desc": true,
+}
+
+func resourceVmQemuTemplate() *schema.Resource {
+	*pxapi.Debug = true
+	return &schema.Resource{
+		Create: resourceVmQemuTemplateCreate,
+		Read:   resourceVmQemuTemplateRead,
+		Update: resourceVmQemuTemplateUpdate,
+		Delete: resourceVmQemuTemplateDelete,
+		Importer: &schema.ResourceImporter{
+			State: importIpconfigs,
+		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultTimeout),
+			Update: schema.DefaultTimeout(defaultTimeout),
+			Delete: schema.DefaultTimeout(defaultTimeout),
+		},
+		CustomizeDiff: resourceVmQemuTemplateCustomizeDiff,
+		Schema:        qemuTemplateSchema(),
+	}
+}
+
+// resourceVmQemuTemplateCustomizeDiff rejects disk ids beyond their bus. A
+// template is built again for any disk change, so shrinking is allowed.
+func resourceVmQemuTemplateCustomizeDiff(d *schema.ResourceDiff, meta interface{}) error {
+	return validateDiskIds(d.Get("disk").(*schema.Set))
+}
+
+// qemuTemplateSchema derives the schema of templates from resourceQemuSchema,
+// so both are built by expandVmQemu. As the disks of a template are the base
+// of its linked clones, every change but the ones of
+// qemuTemplateUpdatableArguments builds a new template.
+func qemuTemplateSchema() map[string]*schema.Schema {
+	templateSchema := map[string]*schema.Schema{}
+	for key, argument := range resourceQemuSchema {
+		if qemuTemplateExcludedArguments[key] {
+			continue
+		}
+		if qemuTemplateUpdatableArguments[key] {
+			templateSchema[key] = argument
+			continue
+		}
+		templateSchema[key] = forceNewSchema(argument)
+	}
+	return templateSchema
+}
+
+// forceNewSchema returns a copy of the schema with ForceNew set on it and its
+// nested arguments.
+func forceNewSchema(s *schema.Schema) *schema.Schema {
+	copied := *s
+	if copied.Optional || copied.Required {
+		copied.ForceNew = true
+	}
+	if elem, ok := copied.Elem.(*schema.Resource); ok {
+		nested := map[string]*schema.Schema{}
+		for key, argument := range elem.Schema {
+			nested[key] = forceNewSchema(argument)
+		}
+		copied.Elem = &schema.Resource{Schema: nested}
+	}
+	return &copied
+}
+
+func resourceVmQemuTemplateCreate(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	pmParallelBegin(pconf)
+	err := createVmQemuTemplate(pconf, d)
+	pmParallelEnd(pconf)
+	if err != nil {
+		return err
+	}
+	return resourceVmQemuTemplateRead(d, meta)
+}
+
+// createVmQemuTemplate builds a VM like proxmox_vm_qemu does, without starting
+// it, and converts it into a template.
+func createVmQemuTemplate(pconf *providerConfiguration, d *schema.ResourceData) error {
+	deadline := time.Now().Add(d.Timeout(schema.TimeoutCreate))
+
+	config := expandVmQemu(d)
+	ipconfigs, err := expandIpconfigs(d)
+	if err != nil {
+		return err
+	}
+	targetNode := d.Get("target_node").(string)
+	pool := d.Get("pool").(string)
+
+	// Unlike VMs, templates are not recycled by name.
+	vmID := d.Get("vmid").(int)
+	if vmID == 0 {
+		vmID, err = nextVmId(pconf)
+		if err != nil {
+			return err
+		}
+		defer releaseVmId(pconf, vmID)
+	}
+	vmr := pxapi.NewVmRef(vmID)
+	vmr.SetNode(targetNode)
+	if pool != "" {
+		vmr.SetPool(pool)
+	}
+
+	err = buildVmQemu(pconf, d, config, ipconfigs, vmr, deadline)
+	if err != nil {
+		return err
+	}
+	d.SetId(resourceId(targetNode, "qemu", vmr.VmId()))
+
+	// The VM was not started, so it can be converted right away.
+	log.Printf("[DEBUG] converting VM %d into a template", vmr.VmId())
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/template", targetNode, vmr.VmId())
+	return runTask(pconf, "POST", path, nil, time.Until(deadline))
+}
+
+func resourceVmQemuTemplateUpdate(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	pmParallelBegin(pconf)
+	err := updateVmQemuTemplate(pconf, d)
+	pmParallelEnd(pconf)
+	if err != nil {
+		return err
+	}
+	return resourceVmQemuTemplateRead(d, meta)
+}
+
+// updateVmQemuTemplate changes the name and description, the only arguments
+// which do not replace the template.
+func updateVmQemuTemplate(pconf *providerConfiguration, d *schema.ResourceData) error {
+	_, _, vmID, err := parseResourceId(d.Id())
+	if err != nil {
+		return err
+	}
+	vmr := pxapi.NewVmRef(vmID)
+	err = pconf.Client.CheckVmRef(vmr)
+	if err != nil {
+		return err
+	}
+
+	params := map[string]interface{}{
+		"name": d.Get("name").(string),
+	}
+	if desc := d.Get("desc").(string); desc != "" {
+		params["description"] = desc
+	} else {
+		params["delete"] = "description"
+	}
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/config", vmr.Node(), vmID)
+	return runTask(pconf, "POST", path, params, d.Timeout(schema.TimeoutUpdate))
+}
+
+func resourceVmQemuTemplateRead(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	pmParallelBegin(pconf)
+	defer pmParallelEnd(pconf)
+
+	client := pconf.Client
+	_, _, vmID, err := parseResourceId(d.Id())
+	if err != nil {
+		d.SetId("")
+		return err
+	}
+	vmr := pxapi.NewVmRef(vmID)
+	_, err = client.GetVmInfo(vmr)
+	if err != nil {
+		if strings.HasSuffix(err.Error(), "not found") {
+			log.Printf("[WARN] template %d is gone, removing it from state", vmID)
+			d.SetId("")
+			return nil
+		}
+		return err
+	}
+	vmConfig, err := client.GetVmConfig(vmr)
+	if err != nil {
+		return err
+	}
+	if template, _ := vmConfig["template"].(float64); template != 1 {
+		return fmt.Errorf("VMID %d is not a template", vmID)
+	}
+	config, err := pxapi.NewConfigQemuFromApi(vmr, client)
+	if err != nil {
+		return err
+	}
+
+	flattenVmQemu(vmr, config, d)
+	if readsIpconfigBlocks(d) {
+		d.Set("ipconfig", flattenIpconfigs(vmConfig))
+	}
+	return nil
+}
+
+func resourceVmQemuTemplateDelete(d *schema.ResourceData, meta interface{}) error {
+	pconf := meta.(*providerConfiguration)
+	pmParallelBegin(pconf)
+	defer pmParallelEnd(pconf)
+
+	_, _, vmID, err := parseResourceId(d.Id())
+	if err != nil {
+		return err
+	}
+	vmr := pxapi.NewVmRef(vmID)
+	_, err = pconf.Client.GetVmInfo(vmr)
+	if err != nil {
+		if strings.HasSuffix(err.Error(), "not found") {
+			log.Printf("[DEBUG] template %d is already gone", vmID)
+			return nil
+		}
+		return err
+	}
+	// Proxmox refuses to delete a template which still has linked clones.
+	return deleteVm(pconf, vmr, false, d.Timeout(schema.TimeoutDelete))
+}
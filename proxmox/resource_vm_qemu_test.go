@@ -0,0 +1,252 @@
+package proxmox
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/terraform"
+)
+
+func TestSetVmQemuId(t *testing.T) {
+	// Without vmid, the VMID is taken from pm_vmid_range during the create.
+	d := schema.TestResourceDataRaw(t, resourceQemuSchema, map[string]interface{}{
+		"name":        "web-1",
+		"target_node": "pve",
+	})
+	setVmQemuId(d, "pve", pxapi.NewVmRef(123))
+
+	if d.Id() != "pve/qemu/123" {
+		t.Errorf("expected id pve/qemu/123, got %s", d.Id())
+	}
+	if vmId := d.Get("vmid").(int); vmId != 123 {
+		t.Errorf("expected vmid 123 before the next refresh, got %d", vmId)
+	}
+}
+
+func testDisk(id int, diskType string, size string) map[string]interface{} {
+	return map[string]interface{}{"id": id, "type": diskType, "storage": "local-lvm", "size": size}
+}
+
+func TestRemovedDiskKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		oldDisks []map[string]interface{}
+		newDisks []map[string]interface{}
+		expected []string
+	}{
+		{
+			"removed disk",
+			[]map[string]interface{}{testDisk(0, "virtio", "10G"), testDisk(1, "virtio", "20G")},
+			[]map[string]interface{}{testDisk(0, "virtio", "10G")},
+			[]string{"virtio1"},
+		},
+		{
+			// The disk on the old bus is removed, the new one is added.
+			"type changed",
+			[]map[string]interface{}{testDisk(0, "virtio", "10G"), testDisk(1, "virtio", "20G")},
+			[]map[string]interface{}{testDisk(0, "virtio", "10G"), testDisk(1, "scsi", "20G")},
+			[]string{"virtio1"},
+		},
+		{
+			"resized disk",
+			[]map[string]interface{}{testDisk(0, "scsi", "10G")},
+			[]map[string]interface{}{testDisk(0, "scsi", "20G")},
+			nil,
+		},
+		{
+			"all disks removed",
+			[]map[string]interface{}{testDisk(0, "scsi", "10G"), testDisk(2, "sata", "20G")},
+			nil,
+			[]string{"sata2", "scsi0"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oldSet := testDeviceSet(t, resourceQemuSchema, "disk", test.oldDisks...)
+			newSet := testDeviceSet(t, resourceQemuSchema, "disk", test.newDisks...)
+			keys := removedDiskKeys(oldSet, newSet)
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, keys)
+			}
+		})
+	}
+}
+
+// testResourceDataChange returns the data of an update of the VM from the
+// old to the new config.
+func testResourceDataChange(t *testing.T, old map[string]interface{}, new map[string]interface{}) *schema.ResourceData {
+	for _, raw := range []map[string]interface{}{old, new} {
+		raw["name"] = "web-1"
+		raw["target_node"] = "pve"
+	}
+	oldData := schema.TestResourceDataRaw(t, resourceQemuSchema, old)
+	oldData.SetId("pve/qemu/100")
+	state := oldData.State()
+	resource := &schema.Resource{Schema: resourceQemuSchema}
+	diff, err := resource.Diff(state, terraform.NewResourceConfigRaw(new), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := schema.InternalMap(resourceQemuSchema).Data(state, diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// newDeviceVmServer fakes VM 100 with the config, which detaches the
+// deleted disks into unusedN, unless they cannot be hot unplugged. It
+// returns the server and the delete parameters it received.
+func newDeviceVmServer(vmConfig map[string]interface{}, hotplug bool) (*fakeApiServer, *[]string) {
+	server := newFakeApiServer()
+	var deletes []string
+	server.handle("GET /nodes/pve/qemu/100/config", func(w http.ResponseWriter, r *http.Request) {
+		writeApiData(w, vmConfig)
+	})
+	server.handle("POST /nodes/pve/qemu/100/config", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		deletes = append(deletes, r.PostForm.Get("delete"))
+		for _, key := range strings.Split(r.PostForm.Get("delete"), ",") {
+			value, _ := vmConfig[key].(string)
+			if rxQemuDiskKey.MatchString(key) {
+				if !hotplug {
+					continue
+				}
+				unused := 0
+				for vmConfig[fmt.Sprintf("unused%d", unused)] != nil {
+					unused++
+				}
+				vmConfig[fmt.Sprintf("unused%d", unused)] = strings.Split(value, ",")[0]
+			}
+			delete(vmConfig, key)
+		}
+		writeApiData(w, testUpid)
+	})
+	handleTaskStatus(server, "OK")
+	return server, &deletes
+}
+
+func TestDeleteRemovedDevices(t *testing.T) {
+	oldConfig := func() map[string]interface{} {
+		return map[string]interface{}{
+			"disk": []interface{}{
+				testDisk(0, "virtio", "10G"),
+				testDisk(1, "virtio", "20G"),
+			},
+			"network": []interface{}{
+				map[string]interface{}{"id": 0, "model": "virtio", "bridge": "vmbr0"},
+				map[string]interface{}{"id": 1, "model": "virtio", "bridge": "vmbr1"},
+			},
+			"serial": []interface{}{
+				map[string]interface{}{"id": 0, "type": "socket"},
+			},
+		}
+	}
+	vmConfig := func() map[string]interface{} {
+		return map[string]interface{}{
+			"virtio0": "local-lvm:vm-100-disk-0,size=10G",
+			"virtio1": "local-lvm:vm-100-disk-1,size=20G",
+			"net0":    "virtio=AA:BB:CC:DD:EE:00,bridge=vmbr0",
+			"net1":    "virtio=AA:BB:CC:DD:EE:01,bridge=vmbr1",
+			"serial0": "socket",
+			// A volume detached before, which is not the provider's to destroy.
+			"unused0": "local-lvm:vm-100-disk-5",
+		}
+	}
+
+	tests := []struct {
+		name     string
+		policy   string
+		newDisks []interface{}
+		hotplug  bool
+		expected []string
+	}{
+		{
+			"detached",
+			"unused",
+			[]interface{}{testDisk(0, "virtio", "10G")},
+			true,
+			[]string{"virtio1,net1,serial0"},
+		},
+		{
+			// Only the unusedN of the removed disk is destroyed.
+			"destroyed",
+			"destroy",
+			[]interface{}{testDisk(0, "virtio", "10G")},
+			true,
+			[]string{"virtio1,net1,serial0", "unused1"},
+		},
+		{
+			"type changed and destroyed",
+			"destroy",
+			[]interface{}{testDisk(0, "virtio", "10G"), testDisk(1, "scsi", "20G")},
+			true,
+			[]string{"virtio1,net1,serial0", "unused1"},
+		},
+		{
+			// The disk stays attached until the VM restarts.
+			"not hot unplugged",
+			"destroy",
+			[]interface{}{testDisk(0, "virtio", "10G")},
+			false,
+			[]string{"virtio1,net1,serial0"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			newConfig := oldConfig()
+			newConfig["disk"] = test.newDisks
+			newConfig["network"] = newConfig["network"].([]interface{})[:1]
+			newConfig["serial"] = []interface{}{}
+			newConfig["disk_detach_policy"] = test.policy
+			d := testResourceDataChange(t, oldConfig(), newConfig)
+
+			server, deletes := newDeviceVmServer(vmConfig(), test.hotplug)
+			defer server.Close()
+			pconf := server.config()
+			client, err := pxapi.NewClient(server.URL+"/api2/json", nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pconf.Client = client
+			vmr := pxapi.NewVmRef(100)
+			vmr.SetNode("pve")
+			vmr.SetVmType("qemu")
+
+			err = deleteRemovedDevices(pconf, d, vmr, time.Now().Add(time.Minute))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// The deletes were recorded by the handler, under the lock of the
+			// server.
+			server.mu.Lock()
+			sent := append([]string(nil), *deletes...)
+			server.mu.Unlock()
+			if !reflect.DeepEqual(sent, test.expected) {
+				t.Errorf("expected the deletes %q, got %q", test.expected, sent)
+			}
+		})
+	}
+}
+
+func TestDeleteRemovedDevicesUnchanged(t *testing.T) {
+	raw := func() map[string]interface{} {
+		return map[string]interface{}{"disk": []interface{}{testDisk(0, "virtio", "10G")}}
+	}
+	d := testResourceDataChange(t, raw(), raw())
+	server := newFakeApiServer()
+	defer server.Close()
+
+	err := deleteRemovedDevices(server.config(), d, pxapi.NewVmRef(100), time.Now().Add(time.Minute))
+	if err != nil || len(server.history()) != 0 {
+		t.Errorf("expected nothing to be deleted, got %v and %q", err, server.history())
+	}
+}
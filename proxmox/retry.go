@@ -0,0 +1,147 @@
+package proxmox
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Messages of Proxmox errors which are worth another attempt: cluster
+// filesystem and VM config lock contention, and pmxcfs being busy syncing.
+var retryableMessages = []string{
+	"can't lock file",
+	"got timeout",
+	"cfs-lock",
+	"unable to get lock",
+	"connection refused",
+	"connection reset by peer",
+}
+
+var rxHttpStatus = regexp.MustCompile(`^[1-5][0-9][0-9] `)
+
+// retryPolicy controls how often and after how long failed Proxmox API calls
+// and tasks are attempted again.
+type retryPolicy struct {
+	MaxRetries int
+	// Delay before the first retry, doubled for every next one.
+	Backoff time.Duration
+}
+
+func (p retryPolicy) delay(attempt int) time.Duration {
+	return p.Backoff * time.Duration(1<<uint(attempt))
+}
+
+// Do calls f until it succeeds, returns an error which is not retryable or
+// runs out of retries.
+func (p retryPolicy) Do(f func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= p.MaxRetries || !isRetryableError(err) {
+			return err
+		}
+		delay := p.delay(attempt)
+		log.Printf("[DEBUG] retrying in %s after: %v", delay, err)
+		time.Sleep(delay)
+	}
+}
+
+func isRetryableMessage(msg string) bool {
+	msg = strings.ToLower(msg)
+	for _, retryable := range retryableMessages {
+		if strings.Contains(msg, retryable) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRetryableError tells whether an operation failing with err can be
+// attempted again.
+func isRetryableError(err error) bool {
+	switch e := err.(type) {
+	case *apiError:
+		// HTTP errors were already retried by retryTransport.
+		return false
+	case *taskError:
+		return isRetryableMessage(e.ExitStatus)
+	case *url.Error:
+		// Requests which did not get a response were retried too.
+		return false
+	default:
+		// pxapi returns both HTTP and task errors as plain strings, the
+		// HTTP ones start with the status code.
+		if rxHttpStatus.MatchString(err.Error()) {
+			return false
+		}
+		return isRetryableMessage(err.Error())
+	}
+}
+
+// retryTransport sends a request again when Proxmox rejected it because of a
+// transient condition.
+type retryTransport struct {
+	policy retryPolicy
+	base   http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt > 0 {
+			attemptReq = req.Clone(req.Context())
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				attemptReq.Body = body
+			}
+		}
+		resp, err := t.base.RoundTrip(attemptReq)
+		if attempt >= t.policy.MaxRetries || !isRetryableResponse(req, resp, err) {
+			return resp, err
+		}
+		// Without GetBody the consumed body cannot be sent again.
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			return resp, err
+		}
+
+		delay := t.policy.delay(attempt)
+		if err != nil {
+			log.Printf("[DEBUG] retrying %s %s in %s after: %v", req.Method, req.URL.Path, delay, err)
+		} else {
+			log.Printf("[DEBUG] retrying %s %s in %s after: %s", req.Method, req.URL.Path, delay, resp.Status)
+			resp.Body.Close()
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func isRetryableResponse(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		// Requests which may have changed something are only sent again
+		// when they certainly did not reach Proxmox.
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			return true
+		}
+		return strings.Contains(err.Error(), "connection refused")
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case 595:
+		// pveproxy could not reach the node handling the request.
+		return true
+	case http.StatusInternalServerError:
+		// Proxmox puts the error message in the status line.
+		return isRetryableMessage(resp.Status)
+	}
+	return false
+}
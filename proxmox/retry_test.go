@@ -0,0 +1,173 @@
+package proxmox
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIsRetryableResponse(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		code      int
+		status    string
+		err       error
+		retryable bool
+	}{
+		{"bad gateway", "POST", 502, "502 Bad Gateway", nil, true},
+		{"service unavailable", "POST", 503, "503 Service Unavailable", nil, true},
+		{"gateway timeout", "PUT", 504, "504 Gateway Timeout", nil, true},
+		{"node unreachable", "POST", 595, "595 Connection refused", nil, true},
+		{"config lock", "POST", 500, "500 can't lock file '/var/lock/qemu-server/lock-100.conf' - got timeout", nil, true},
+		{"cluster lock", "PUT", 500, "500 cfs-lock 'file-user_cfg' error: got lock request timeout", nil, true},
+		{"pmxcfs timeout", "POST", 500, "500 got timeout", nil, true},
+		{"other server error", "POST", 500, "500 VM 100 already running", nil, false},
+		{"ok", "GET", 200, "200 OK", nil, false},
+		{"bad request", "POST", 400, "400 Parameter verification failed.", nil, false},
+		{"unauthorized", "GET", 401, "401 No ticket", nil, false},
+		{"not found", "GET", 404, "404 Not Found", nil, false},
+		{"get connection error", "GET", 0, "", errors.New("read: connection reset by peer"), true},
+		{"post connection reset", "POST", 0, "", errors.New("read: connection reset by peer"), false},
+		{"post connection refused", "POST", 0, "", errors.New("dial tcp: connection refused"), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "https://pve:8006/api2/json/nodes", nil)
+			var resp *http.Response
+			if test.err == nil {
+				resp = &http.Response{StatusCode: test.code, Status: test.status}
+			}
+			if retryable := isRetryableResponse(req, resp, test.err); retryable != test.retryable {
+				t.Errorf("expected retryable %v, got %v", test.retryable, retryable)
+			}
+		})
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"api error", &apiError{Method: "POST", Path: "/nodes", StatusCode: 500, Status: "500 got timeout"}, false},
+		{"locked task", &taskError{Upid: "UPID:pve:1", ExitStatus: "can't lock file '/var/lock/qemu-server/lock-100.conf' - got timeout"}, true},
+		{"failed task", &taskError{Upid: "UPID:pve:1", ExitStatus: "storage 'local-lvm' does not exist"}, false},
+		{"pxapi locked task", errors.New("can't lock file '/var/lock/qemu-server/lock-100.conf' - got timeout"), true},
+		{"pxapi cluster lock", errors.New("unable to get lock - timeout"), true},
+		{"pxapi failed task", errors.New("VM 100 already running"), false},
+		// Responses and connection errors were already retried by
+		// retryTransport.
+		{"pxapi lock response", errors.New("500 Can't lock file '/var/lock/qemu-server/lock-100.conf' - got timeout"), false},
+		{"pxapi unavailable", errors.New("503 Service Unavailable"), false},
+		{"pxapi other response", errors.New("500 VM 100 already running"), false},
+		{"connection refused", &url.Error{Op: "Post", URL: "https://pve:8006/api2/json/nodes", Err: errors.New("dial tcp 10.0.0.1:8006: connection refused")}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if retryable := isRetryableError(test.err); retryable != test.retryable {
+				t.Errorf("expected retryable %v, got %v", test.retryable, retryable)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	policy := retryPolicy{MaxRetries: 2, Backoff: time.Millisecond}
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		fails    bool
+	}{
+		{"success", []error{nil}, 1, false},
+		{"retried", []error{errors.New("got timeout"), nil}, 2, false},
+		{"out of retries", []error{errors.New("got timeout"), errors.New("got timeout"), errors.New("got timeout")}, 3, true},
+		{"fatal", []error{errors.New("permission denied"), nil}, 1, true},
+		{"already retried response", []error{errors.New("500 got timeout"), nil}, 1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			err := policy.Do(func() error {
+				err := test.errs[attempts]
+				attempts++
+				return err
+			})
+			if attempts != test.attempts {
+				t.Errorf("expected %d attempts, got %d", test.attempts, attempts)
+			}
+			if (err != nil) != test.fails {
+				t.Errorf("expected failure %v, got %v", test.fails, err)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := retryPolicy{Backoff: time.Second}
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if delay := policy.delay(attempt); delay != expected {
+			t.Errorf("attempt %d: expected %s, got %s", attempt, expected, delay)
+		}
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		status   int
+	}{
+		{"success", []int{200}, 1, 200},
+		{"service unavailable", []int{503, 503, 200}, 3, 200},
+		{"out of retries", []int{502, 502, 502, 502}, 3, 502},
+		{"fatal", []int{400, 200}, 1, 400},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var bodies []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				mu.Lock()
+				status := test.statuses[len(bodies)]
+				bodies = append(bodies, string(body))
+				mu.Unlock()
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			client := &http.Client{Transport: &retryTransport{
+				policy: retryPolicy{MaxRetries: 2, Backoff: time.Millisecond},
+				base:   http.DefaultTransport,
+			}}
+			resp, err := client.Post(server.URL, "application/x-www-form-urlencoded", strings.NewReader("vmid=100"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d", test.status, resp.StatusCode)
+			}
+			if len(bodies) != test.attempts {
+				t.Fatalf("expected %d attempts, got %d", test.attempts, len(bodies))
+			}
+			// Every attempt sends the whole body again.
+			for i, body := range bodies {
+				if body != "vmid=100" {
+					t.Errorf("attempt %d: expected body vmid=100, got %q", i, body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package proxmox
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+)
+
+// Tickets are valid for two hours, renew them well before.
+const ticketRenewAfter = time.Hour
+
+// apiSession sends the Proxmox API requests pxapi does not expose, like the
+// ones returning a task UPID. Its http.Client is shared with the pxapi.Client,
+// so the credentials added by authTransport are used by both.
+type apiSession struct {
+	ApiUrl     string
+	httpClient *http.Client
+
+	// Kept to login again once the ticket expired.
+	username string
+	password string
+	otp      string
+
+	// Serializes logins, so concurrent requests failing on the same expired
+	// ticket only trigger one new login.
+	loginMutex sync.Mutex
+
+	mutex        sync.Mutex
+	ticket       string
+	ticketIssued time.Time
+	csrfToken    string
+	// Set when authenticating with an API token, which replaces the ticket.
+	apiToken string
+}
+
+// apiError is returned for every non 2xx response of the Proxmox API.
+type apiError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	Errors     interface{}
+}
+
+func (e *apiError) Error() string {
+	if e.Errors != nil {
+		return fmt.Sprintf("%s %s: %s: %v", e.Method, e.Path, e.Status, e.Errors)
+	}
+	return fmt.Sprintf("%s %s: %s", e.Method, e.Path, e.Status)
+}
+
+// authTransport adds the session credentials to every outgoing request. When
+// a request is rejected because the ticket expired, it logs in again and
+// retries the request once.
+type authTransport struct {
+	session *apiSession
+	base    http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isLoginRequest(req) {
+		return t.base.RoundTrip(req)
+	}
+	t.session.renewTicket()
+
+	// A RoundTripper must not modify the request it was given.
+	authReq := req.Clone(req.Context())
+	usedTicket := t.session.authorize(authReq.Header)
+	resp, err := t.base.RoundTrip(authReq)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || usedTicket == "" {
+		return resp, err
+	}
+	// The body of the first attempt was consumed, it can only be sent again
+	// when the request knows how to recreate it.
+	if req.Body != nil && req.GetBody == nil {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	log.Print("[DEBUG] Proxmox API ticket rejected, logging in again")
+	err = t.session.relogin(usedTicket)
+	if err != nil {
+		return nil, fmt.Errorf("Proxmox API ticket expired and logging in again failed: %v", err)
+	}
+	retryReq := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retryReq.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	t.session.authorize(retryReq.Header)
+	return t.base.RoundTrip(retryReq)
+}
+
+func isLoginRequest(req *http.Request) bool {
+	return strings.HasSuffix(req.URL.Path, "/access/ticket")
+}
+
+func newApiSession(apiUrl string, tlsconf *tls.Config, retry retryPolicy) *apiSession {
+	session := &apiSession{ApiUrl: apiUrl}
+	session.httpClient = &http.Client{
+		Transport: &authTransport{
+			session: session,
+			base: &retryTransport{
+				policy: retry,
+				base: &http.Transport{
+					TLSClientConfig:    tlsconf,
+					DisableCompression: true,
+				},
+			},
+		},
+	}
+	return session
+}
+
+// authorize adds the credentials to the headers and returns the ticket used,
+// if any.
+func (s *apiSession) authorize(header http.Header) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.apiToken != "" {
+		header.Set("Authorization", "PVEAPIToken="+s.apiToken)
+		return ""
+	}
+	if s.ticket != "" {
+		header.Set("Cookie", "PVEAuthCookie="+s.ticket)
+		header.Set("CSRFPreventionToken", s.csrfToken)
+	}
+	return s.ticket
+}
+
+func (s *apiSession) currentTicket() (string, time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.ticket, s.ticketIssued
+}
+
+// Login requests an authentication ticket, which is then sent along with
+// every request of the session.
+func (s *apiSession) Login(username string, password string, otp string) error {
+	s.loginMutex.Lock()
+	defer s.loginMutex.Unlock()
+	s.username = username
+	s.password = password
+	s.otp = otp
+	return s.login(username, password, otp)
+}
+
+// relogin logs in again with the stored credentials, unless another request
+// already replaced the rejected ticket.
+func (s *apiSession) relogin(rejectedTicket string) error {
+	s.loginMutex.Lock()
+	defer s.loginMutex.Unlock()
+	if ticket, _ := s.currentTicket(); ticket != rejectedTicket {
+		return nil
+	}
+	return s.login(s.username, s.password, s.otp)
+}
+
+// renewTicket exchanges a ticket older than ticketRenewAfter for a new one.
+// Proxmox accepts a valid ticket as password, so this also works for users
+// with 2FA, whose OTP code is only valid once.
+func (s *apiSession) renewTicket() {
+	if ticket, issued := s.currentTicket(); ticket == "" || time.Since(issued) < ticketRenewAfter {
+		return
+	}
+	s.loginMutex.Lock()
+	defer s.loginMutex.Unlock()
+	ticket, issued := s.currentTicket()
+	if time.Since(issued) < ticketRenewAfter {
+		return
+	}
+	err := s.login(s.username, ticket, "")
+	if err != nil {
+		// Not fatal, the request will login again if the ticket expired.
+		log.Printf("[DEBUG] could not renew Proxmox API ticket: %v", err)
+	}
+}
+
+func (s *apiSession) login(username string, password string, otp string) error {
+	params := map[string]interface{}{
+		"username": username,
+		"password": password,
+	}
+	if otp != "" {
+		params["otp"] = otp
+	}
+	resp, err := s.Post("/access/ticket", params)
+	if err != nil {
+		return err
+	}
+	data, ok := resp["data"].(map[string]interface{})
+	if !ok {
+		return errors.New("Invalid login response")
+	}
+	// Check if the 2FA was required.
+	if data["NeedTFA"] == 1.0 {
+		return errors.New("Missing TFA code")
+	}
+	ticket, _ := data["ticket"].(string)
+	csrfToken, _ := data["CSRFPreventionToken"].(string)
+	if ticket == "" {
+		return errors.New("Invalid login response: no ticket")
+	}
+
+	s.mutex.Lock()
+	s.ticket = ticket
+	s.ticketIssued = time.Now()
+	s.csrfToken = csrfToken
+	s.mutex.Unlock()
+	return nil
+}
+
+// SetApiToken makes the session authenticate with an API token, which does
+// not need a login. tokenID has the user@realm!tokenname format.
+func (s *apiSession) SetApiToken(tokenID string, secret string) {
+	s.mutex.Lock()
+	s.apiToken = tokenID + "=" + secret
+	s.mutex.Unlock()
+}
+
+// Request sends params form encoded, or as query string for GET and DELETE,
+// and returns the decoded JSON response.
+func (s *apiSession) Request(method string, path string, params map[string]interface{}) (map[string]interface{}, error) {
+	reqUrl := s.ApiUrl + path
+	var body io.Reader
+	if len(params) > 0 {
+		encoded := pxapi.ParamsToBody(params)
+		if method == http.MethodGet || method == http.MethodDelete {
+			reqUrl += "?" + string(encoded)
+		} else {
+			body = bytes.NewReader(encoded)
+		}
+	}
+	req, err := http.NewRequest(method, reqUrl, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	return s.do(req, method, path)
+}
+
+// Upload sends params and the content of file as multipart form, like the
+// upload endpoints of Proxmox expect. The file is streamed rather than read
+// into memory, as ISO images can be several gigabytes.
+func (s *apiSession) Upload(path string, params map[string]interface{}, field string, fileName string, file *os.File) (map[string]interface{}, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// Proxmox handles the fields in order, so the parameters go before the
+	// file. Everything but the file is buffered to know the Content-Length,
+	// which pveproxy requires.
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		err = writer.WriteField(key, fmt.Sprint(params[key]))
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = writer.CreateFormFile(field, fileName)
+	if err != nil {
+		return nil, err
+	}
+	headLen := form.Len()
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	head := form.Bytes()[:headLen]
+	tail := form.Bytes()[headLen:]
+
+	getBody := func() (io.ReadCloser, error) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(io.MultiReader(bytes.NewReader(head), file, bytes.NewReader(tail))), nil
+	}
+	body, err := getBody()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.ApiUrl+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.GetBody = getBody
+	req.ContentLength = int64(len(head)) + info.Size() + int64(len(tail))
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return s.do(req, http.MethodPost, path)
+}
+
+// do sends the request and decodes the JSON response.
+func (s *apiSession) do(req *http.Request, method string, path string) (map[string]interface{}, error) {
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	rbody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %s", err)
+	}
+
+	var data map[string]interface{}
+	jsonErr := json.Unmarshal(rbody, &data)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return data, &apiError{
+			Method:     method,
+			Path:       path,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Errors:     data["errors"],
+		}
+	}
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+	return data, nil
+}
+
+func (s *apiSession) Get(path string, params map[string]interface{}) (map[string]interface{}, error) {
+	return s.Request(http.MethodGet, path, params)
+}
+
+func (s *apiSession) Post(path string, params map[string]interface{}) (map[string]interface{}, error) {
+	return s.Request(http.MethodPost, path, params)
+}
+
+func (s *apiSession) Put(path string, params map[string]interface{}) (map[string]interface{}, error) {
+	return s.Request(http.MethodPut, path, params)
+}
+
+func (s *apiSession) Delete(path string, params map[string]interface{}) (map[string]interface{}, error) {
+	return s.Request(http.MethodDelete, path, params)
+}
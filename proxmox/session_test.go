@@ -0,0 +1,212 @@
+package proxmox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAuthServer is a Proxmox API which issues tickets and only accepts the
+// latest one, or the API token.
+type fakeAuthServer struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	ticket        string
+	apiToken      string
+	rejectTickets bool
+	// Passwords of the logins, and the requests other than logins.
+	logins   []string
+	requests []fakeAuthRequest
+}
+
+type fakeAuthRequest struct {
+	body string
+	csrf string
+}
+
+func newFakeAuthServer() *fakeAuthServer {
+	s := &fakeAuthServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *fakeAuthServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.ParseForm()
+	if strings.HasSuffix(r.URL.Path, "/access/ticket") {
+		s.logins = append(s.logins, r.PostForm.Get("password"))
+		s.ticket = fmt.Sprintf("ticket-%d", len(s.logins))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"ticket":              s.ticket,
+				"CSRFPreventionToken": "csrf-" + s.ticket,
+			},
+		})
+		return
+	}
+
+	s.requests = append(s.requests, fakeAuthRequest{r.PostForm.Encode(), r.Header.Get("CSRFPreventionToken")})
+	cookie, _ := r.Cookie("PVEAuthCookie")
+	authorized := cookie != nil && cookie.Value == s.ticket && !s.rejectTickets
+	if s.apiToken != "" {
+		authorized = r.Header.Get("Authorization") == "PVEAPIToken="+s.apiToken
+	}
+	if !authorized {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": "UPID:pve:1"})
+}
+
+// expireTicket makes the server reject every ticket issued so far.
+func (s *fakeAuthServer) expireTicket() {
+	s.mu.Lock()
+	s.ticket = "expired"
+	s.mu.Unlock()
+}
+
+func (s *fakeAuthServer) setApiToken(token string) {
+	s.mu.Lock()
+	s.apiToken = token
+	s.mu.Unlock()
+}
+
+func (s *fakeAuthServer) history() ([]string, []fakeAuthRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.logins...), append([]fakeAuthRequest(nil), s.requests...)
+}
+
+func newTestSession(t *testing.T, server *fakeAuthServer) *apiSession {
+	session := newApiSession(server.URL+"/api2/json", nil, retryPolicy{})
+	if err := session.Login("terraform@pve", "secret", ""); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	return session
+}
+
+func TestSessionLogin(t *testing.T) {
+	server := newFakeAuthServer()
+	defer server.Close()
+	session := newTestSession(t, server)
+
+	_, err := session.Post("/nodes/pve/qemu/100/status/start", map[string]interface{}{"timeout": 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, requests := server.history()
+	if requests[0].csrf != "csrf-ticket-1" {
+		t.Errorf("expected the CSRF token of the ticket, got %q", requests[0].csrf)
+	}
+}
+
+func TestSessionReloginOnExpiredTicket(t *testing.T) {
+	server := newFakeAuthServer()
+	defer server.Close()
+	session := newTestSession(t, server)
+	server.expireTicket()
+
+	_, err := session.Post("/nodes/pve/qemu/100/config", map[string]interface{}{"name": "web-1"})
+	if err != nil {
+		t.Fatalf("expected the request to succeed after logging in again: %v", err)
+	}
+	logins, requests := server.history()
+	if len(logins) != 2 || logins[1] != "secret" {
+		t.Errorf("expected a second login with the password, got %q", logins)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected the request to be sent twice, got %d", len(requests))
+	}
+	if requests[1].body != "name=web-1" || requests[1].csrf != "csrf-ticket-2" {
+		t.Errorf("expected the retried request to have the body and new ticket, got %+v", requests[1])
+	}
+}
+
+func TestSessionRelogsInOnce(t *testing.T) {
+	server := newFakeAuthServer()
+	defer server.Close()
+	session := newTestSession(t, server)
+
+	// Tickets which keep being rejected only lead to one more login.
+	server.mu.Lock()
+	server.rejectTickets = true
+	server.mu.Unlock()
+
+	_, err := session.Get("/nodes", nil)
+	if err == nil {
+		t.Fatal("expected the request to fail")
+	}
+	if apiErr, ok := err.(*apiError); !ok || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected a 401 apiError, got %v", err)
+	}
+	if logins, requests := server.history(); len(logins) != 2 || len(requests) != 2 {
+		t.Errorf("expected one more login and attempt, got %d logins and %d requests", len(logins), len(requests))
+	}
+}
+
+func TestSessionRenewTicket(t *testing.T) {
+	server := newFakeAuthServer()
+	defer server.Close()
+	session := newTestSession(t, server)
+
+	session.mutex.Lock()
+	session.ticketIssued = time.Now().Add(-ticketRenewAfter - time.Minute)
+	session.mutex.Unlock()
+
+	_, err := session.Get("/nodes", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The old ticket is exchanged for a new one, no password or OTP needed.
+	logins, requests := server.history()
+	if len(logins) != 2 || logins[1] != "ticket-1" {
+		t.Errorf("expected the ticket to be renewed with the old ticket, got %q", logins)
+	}
+	if len(requests) != 1 || requests[0].csrf != "csrf-ticket-2" {
+		t.Errorf("expected the request to be sent once with the new ticket, got %+v", requests)
+	}
+}
+
+func TestSessionApiToken(t *testing.T) {
+	server := newFakeAuthServer()
+	defer server.Close()
+	server.setApiToken("terraform@pve!ci=secret")
+
+	session := newApiSession(server.URL+"/api2/json", nil, retryPolicy{})
+	session.SetApiToken("terraform@pve!ci", "secret")
+	_, err := session.Get("/nodes", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A rejected token is not a reason to login.
+	server.setApiToken("terraform@pve!ci=other")
+	_, err = session.Get("/nodes", nil)
+	if err == nil {
+		t.Fatal("expected a rejected token to fail the request")
+	}
+	if logins, _ := server.history(); len(logins) != 0 {
+		t.Errorf("expected no login with an API token, got %d", len(logins))
+	}
+}
+
+func TestIsLoginRequest(t *testing.T) {
+	tests := map[string]bool{
+		"https://pve:8006/api2/json/access/ticket": true,
+		"https://pve:8006/api2/json/access/users":  false,
+		"https://pve:8006/api2/json/nodes":         false,
+	}
+	for url, expected := range tests {
+		req := httptest.NewRequest("POST", url, nil)
+		if isLoginRequest(req) != expected {
+			t.Errorf("isLoginRequest(%s): expected %v", url, expected)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package proxmox
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+)
+
+// diskSize is the size of a disk or volume in bytes.
+type diskSize int64
+
+const (
+	kibibyte diskSize = 1 << (10 * (iota + 1))
+	mebibyte
+	gibibyte
+	tebibyte
+)
+
+var rxSize = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)(B|[KMGT](?:iB|B)?)?$`)
+
+// Bytes per unit. Like Proxmox, the single letter units are binary.
+var sizeUnits = map[string]diskSize{
+	"B":   1,
+	"K":   kibibyte,
+	"KiB": kibibyte,
+	"KB":  1000,
+	"M":   mebibyte,
+	"MiB": mebibyte,
+	"MB":  1000 * 1000,
+	"G":   gibibyte,
+	"GiB": gibibyte,
+	"GB":  1000 * 1000 * 1000,
+	"T":   tebibyte,
+	"TiB": tebibyte,
+	"TB":  1000 * 1000 * 1000 * 1000,
+}
+
+// parseDiskSize parses the size argument of a disk, like 10G, 512MiB or 20GB.
+// A size without unit is in gigabytes, as the disk_gb argument was.
+func parseDiskSize(s string) (diskSize, error) {
+	return parseSize(s, gibibyte)
+}
+
+// parseApiSize parses a size reported by Proxmox, which are bytes without
+// unit.
+func parseApiSize(s string) (diskSize, error) {
+	return parseSize(s, 1)
+}
+
+func parseSize(s string, bareUnit diskSize) (diskSize, error) {
+	match := rxSize.FindStringSubmatch(s)
+	if match == nil {
+		return 0, fmt.Errorf("invalid size %q, expected a number with an optional unit K, M, G or T, or KB, MB, GB or TB for decimal units, like 10G", s)
+	}
+	number, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %v", s, err)
+	}
+	unit := bareUnit
+	if match[2] != "" {
+		unit = sizeUnits[match[2]]
+	}
+	return diskSize(math.Ceil(number * float64(unit))), nil
+}
+
+// String formats the size for Proxmox, in the largest binary unit it is a
+// whole multiple of. Sizes not a multiple of a kibibyte are rounded up to one.
+func (s diskSize) String() string {
+	for _, unit := range []struct {
+		size   diskSize
+		suffix string
+	}{{tebibyte, "T"}, {gibibyte, "G"}, {mebibyte, "M"}} {
+		if s != 0 && s%unit.size == 0 {
+			return fmt.Sprintf("%d%s", s/unit.size, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dK", (s+kibibyte-1)/kibibyte)
+}
+
+// expandDiskSizes formats the size of disks or mountpoints for Proxmox.
+func expandDiskSizes(devices pxapi.QemuDevices) pxapi.QemuDevices {
+	for _, device := range devices {
+		if size, err := parseDiskSize(fmt.Sprint(device["size"])); err == nil {
+			device["size"] = size.String()
+		}
+	}
+	return devices
+}
+
+// flattenDiskSizes formats the sizes Proxmox reports like parseDiskSize
+// expects them. The configured size is kept when it is the same, so 20GB does
+// not show a diff against the 19531250K Proxmox reports.
+func flattenDiskSizes(activeDevices pxapi.QemuDevices, configDevices pxapi.QemuDevices) {
+	for id, device := range activeDevices {
+		size, err := parseApiSize(fmt.Sprint(device["size"]))
+		if err != nil {
+			continue
+		}
+		device["size"] = size.String()
+		if configDevice, ok := configDevices[id]; ok {
+			configSize, err := parseDiskSize(fmt.Sprint(configDevice["size"]))
+			if err == nil && configSize == size {
+				device["size"] = configDevice["size"]
+			}
+		}
+	}
+}
+
+// validateDiskSize checks a size argument, see parseDiskSize.
+func validateDiskSize(i interface{}, k string) ([]string, []error) {
+	v, ok := i.(string)
+	if !ok {
+		return nil, []error{fmt.Errorf("expected type of %s to be string", k)}
+	}
+	if _, err := parseDiskSize(v); err != nil {
+		return nil, []error{fmt.Errorf("%s: %v", k, err)}
+	}
+	return nil, nil
+}
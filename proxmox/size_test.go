@@ -0,0 +1,137 @@
+package proxmox
+
+import (
+	"testing"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+)
+
+func TestParseDiskSize(t *testing.T) {
+	tests := []struct {
+		size     string
+		expected diskSize
+		valid    bool
+	}{
+		{"10", 10 * gibibyte, true},
+		{"10G", 10 * gibibyte, true},
+		{"10GiB", 10 * gibibyte, true},
+		{"10GB", 10 * 1000 * 1000 * 1000, true},
+		{"512M", 512 * mebibyte, true},
+		{"512MiB", 512 * mebibyte, true},
+		{"500MB", 500 * 1000 * 1000, true},
+		{"64K", 64 * kibibyte, true},
+		{"64KB", 64 * 1000, true},
+		{"2T", 2 * tebibyte, true},
+		{"1TB", 1000 * 1000 * 1000 * 1000, true},
+		{"1.5G", 1536 * mebibyte, true},
+		{"0.5T", 512 * gibibyte, true},
+		{"1024B", 1024, true},
+		{"10 G", 0, false},
+		{" 10G", 0, false},
+		{"10G ", 0, false},
+		{"10g", 0, false},
+		{"10X", 0, false},
+		{"10GX", 0, false},
+		{"10Gb", 0, false},
+		{"G", 0, false},
+		{"-10G", 0, false},
+		{"1.G", 0, false},
+		{"", 0, false},
+	}
+	for _, test := range tests {
+		size, err := parseDiskSize(test.size)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("parseDiskSize(%q): expected an error, got %d", test.size, size)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseDiskSize(%q): unexpected error: %v", test.size, err)
+		} else if size != test.expected {
+			t.Errorf("parseDiskSize(%q): expected %d, got %d", test.size, test.expected, size)
+		}
+	}
+}
+
+func TestParseApiSize(t *testing.T) {
+	tests := []struct {
+		size     string
+		expected diskSize
+	}{
+		{"10737418240", 10 * gibibyte},
+		{"10G", 10 * gibibyte},
+		{"8192M", 8 * gibibyte},
+		{"0", 0},
+	}
+	for _, test := range tests {
+		size, err := parseApiSize(test.size)
+		if err != nil {
+			t.Errorf("parseApiSize(%q): unexpected error: %v", test.size, err)
+		} else if size != test.expected {
+			t.Errorf("parseApiSize(%q): expected %d, got %d", test.size, test.expected, size)
+		}
+	}
+}
+
+func TestDiskSizeString(t *testing.T) {
+	tests := []struct {
+		size     diskSize
+		expected string
+	}{
+		{10 * gibibyte, "10G"},
+		{1536 * mebibyte, "1536M"},
+		{2 * tebibyte, "2T"},
+		{1024 * gibibyte, "1T"},
+		{64 * kibibyte, "64K"},
+		{20 * 1000 * 1000 * 1000, "19531250K"},
+		{1, "1K"},
+		{1025, "2K"},
+		{0, "0K"},
+	}
+	for _, test := range tests {
+		if s := test.size.String(); s != test.expected {
+			t.Errorf("diskSize(%d).String(): expected %s, got %s", test.size, test.expected, s)
+		}
+	}
+}
+
+func TestFlattenDiskSizes(t *testing.T) {
+	active := pxapi.QemuDevices{
+		0: {"size": "19531250K"},
+		1: {"size": "10G"},
+		2: {"size": "32G"},
+	}
+	config := pxapi.QemuDevices{
+		0: {"size": "20GB"},
+		1: {"size": "10240M"},
+		2: {"size": "16G"},
+	}
+	flattenDiskSizes(active, config)
+
+	// Sizes equal to the configured one keep its notation, others are
+	// formatted in the largest unit.
+	expected := map[int]string{0: "20GB", 1: "10240M", 2: "32G"}
+	for id, size := range expected {
+		if active[id]["size"] != size {
+			t.Errorf("disk %d: expected size %s, got %v", id, size, active[id]["size"])
+		}
+	}
+}
+
+func TestValidateDiskSize(t *testing.T) {
+	tests := map[string]bool{
+		"10G":   true,
+		"500MB": true,
+		"32":    true,
+		"10 G":  false,
+		"10X":   false,
+		"":      false,
+	}
+	for size, valid := range tests {
+		_, errs := validateDiskSize(size, "size")
+		if (len(errs) == 0) != valid {
+			t.Errorf("validateDiskSize(%q): expected valid %v, got %v", size, valid, errs)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package proxmox
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+)
+
+const (
+	// Time between two task status checks.
+	taskPollInterval = 2 * time.Second
+	// Default timeout of the resource operations, which bounds the tasks
+	// they wait for.
+	defaultTimeout = 20 * time.Minute
+	// Number of task log lines included in the error of a failed task.
+	taskLogTail = 10
+)
+
+var rxUpidNode = regexp.MustCompile("^UPID:([^:]+):")
+
+// taskError describes a Proxmox task which did not exit with OK.
+type taskError struct {
+	Upid       string
+	ExitStatus string
+	Log        []string
+}
+
+func (e *taskError) Error() string {
+	msg := fmt.Sprintf("task %s failed: %s", e.Upid, e.ExitStatus)
+	if len(e.Log) > 0 {
+		msg += "\n" + strings.Join(e.Log, "\n")
+	}
+	return msg
+}
+
+func taskNode(upid string) (string, error) {
+	match := rxUpidNode.FindStringSubmatch(upid)
+	if match == nil {
+		return "", fmt.Errorf("Invalid task UPID: %s", upid)
+	}
+	return match[1], nil
+}
+
+// startTask sends a request which makes Proxmox run a task and returns the
+// UPID of that task. An empty UPID means the request was handled synchronously.
+func startTask(session *apiSession, method string, path string, params map[string]interface{}) (string, error) {
+	resp, err := session.Request(method, path, params)
+	if err != nil {
+		return "", err
+	}
+	upid, _ := resp["data"].(string)
+	return upid, nil
+}
+
+// runTask starts a task and waits for it. A task failing on a transient error,
+// like a lock timeout, is started again according to the retry policy.
+func runTask(pconf *providerConfiguration, method string, path string, params map[string]interface{}, timeout time.Duration) error {
+	return pconf.Retry.Do(func() error {
+		upid, err := startTask(pconf.Session, method, path, params)
+		if err != nil {
+			return err
+		}
+		return waitForTask(pconf.Session, upid, timeout)
+	})
+}
+
+// waitForTask polls the status of the task until it stops or the timeout
+// expires. A task which does not exit with OK is returned as a taskError.
+func waitForTask(session *apiSession, upid string, timeout time.Duration) error {
+	if upid == "" {
+		return nil
+	}
+	node, err := taskNode(upid)
+	if err != nil {
+		return err
+	}
+	statusPath := fmt.Sprintf("/nodes/%s/tasks/%s/status", node, url.PathEscape(upid))
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := session.Get(statusPath, nil)
+		if err != nil {
+			return err
+		}
+		status, _ := resp["data"].(map[string]interface{})
+		if status["status"] == "stopped" {
+			exitStatus, _ := status["exitstatus"].(string)
+			// Tasks which logged warnings still did their job.
+			if exitStatus == "OK" || strings.HasPrefix(exitStatus, "WARNINGS") {
+				return nil
+			}
+			return &taskError{
+				Upid:       upid,
+				ExitStatus: exitStatus,
+				Log:        taskLogLines(session, node, upid),
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timeout after %s waiting for task %s", timeout, upid)
+		}
+		time.Sleep(taskPollInterval)
+	}
+}
+
+// taskLogLines returns the last taskLogTail lines of the task log. Failing to
+// read the log is not an error, as it is only used to explain a failed task.
+func taskLogLines(session *apiSession, node string, upid string) []string {
+	logPath := fmt.Sprintf("/nodes/%s/tasks/%s/log", node, url.PathEscape(upid))
+	resp, err := session.Get(logPath, map[string]interface{}{"start": 0, "limit": 1})
+	if err != nil {
+		log.Printf("[DEBUG] could not read log of task %s: %v", upid, err)
+		return nil
+	}
+	start := 0
+	if total, ok := resp["total"].(float64); ok && int(total) > taskLogTail {
+		start = int(total) - taskLogTail
+	}
+	resp, err = session.Get(logPath, map[string]interface{}{"start": start, "limit": taskLogTail})
+	if err != nil {
+		log.Printf("[DEBUG] could not read log of task %s: %v", upid, err)
+		return nil
+	}
+	var lines []string
+	entries, _ := resp["data"].([]interface{})
+	for _, entry := range entries {
+		if line, ok := entry.(map[string]interface{})["t"].(string); ok {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// vmStatusChange runs a status action (start, stop, shutdown...) on a VM or
+// container and waits for its task.
+func vmStatusChange(pconf *providerConfiguration, vmr *pxapi.VmRef, action string, timeout time.Duration) error {
+	err := pconf.Client.CheckVmRef(vmr)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/nodes/%s/%s/%d/status/%s", vmr.Node(), vmr.GetVmType(), vmr.VmId(), action)
+	return runTask(pconf, "POST", path, nil, timeout)
+}
+
+// vmShutdown asks the guest to shut down, through ACPI or the QEMU guest agent,
+// and waits for it. When the guest did not stop within shutdownTimeout, Proxmox
+// stops it hard if forceStop is set, otherwise the shutdown task fails.
+func vmShutdown(pconf *providerConfiguration, vmr *pxapi.VmRef, shutdownTimeout time.Duration, forceStop bool, timeout time.Duration) error {
+	err := pconf.Client.CheckVmRef(vmr)
+	if err != nil {
+		return err
+	}
+	params := map[string]interface{}{
+		"timeout":   int(shutdownTimeout.Seconds()),
+		"forceStop": forceStop,
+	}
+	path := fmt.Sprintf("/nodes/%s/%s/%d/status/shutdown", vmr.Node(), vmr.GetVmType(), vmr.VmId())
+	return runTask(pconf, "POST", path, params, timeout)
+}
+
+// vmIsRunning reports whether the VM or container is currently running.
+func vmIsRunning(pconf *providerConfiguration, vmr *pxapi.VmRef) (bool, error) {
+	vmState, err := pconf.Client.GetVmState(vmr)
+	if err != nil {
+		return false, err
+	}
+	return vmState["status"] == "running", nil
+}
+
+// cloneVm clones sourceVmr into vmr, with the same parameters pxapi's
+// ConfigQemu.CloneVm would use, and waits for the clone task.
+func cloneVm(pconf *providerConfiguration, config pxapi.ConfigQemu, sourceVmr *pxapi.VmRef, vmr *pxapi.VmRef, timeout time.Duration) error {
+	vmr.SetVmType("qemu")
+	fullClone := 1
+	if config.FullClone != nil {
+		fullClone = *config.FullClone
+	}
+	params := map[string]interface{}{
+		"newid":  vmr.VmId(),
+		"target": vmr.Node(),
+		"name":   config.Name,
+		"full":   fullClone,
+	}
+	if vmr.Pool() != "" {
+		params["pool"] = vmr.Pool()
+	}
+	if fullClone == 1 {
+		storage := config.Storage
+		if disk0Storage, ok := config.QemuDisks[0]["storage"].(string); ok && len(disk0Storage) > 0 {
+			storage = disk0Storage
+		}
+		if storage != "" {
+			params["storage"] = storage
+		}
+	}
+
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/clone", sourceVmr.Node(), sourceVmr.VmId())
+	return runTask(pconf, "POST", path, params, timeout)
+}
+
+// migrateVm moves the VM to targetNode and waits for the migration task.
+func migrateVm(pconf *providerConfiguration, vmr *pxapi.VmRef, targetNode string, online bool, timeout time.Duration) error {
+	err := pconf.Client.CheckVmRef(vmr)
+	if err != nil {
+		return err
+	}
+	params := map[string]interface{}{
+		"target": targetNode,
+		"online": online,
+	}
+	path := fmt.Sprintf("/nodes/%s/%s/%d/migrate", vmr.Node(), vmr.GetVmType(), vmr.VmId())
+	return runTask(pconf, "POST", path, params, timeout)
+}
+
+// resizeVmDisk grows a disk of a VM or container to size and waits for the
+// resize, which only older Proxmox versions handle synchronously. The size is
+// absolute, so a retried resize does not grow the disk twice.
+func resizeVmDisk(pconf *providerConfiguration, vmr *pxapi.VmRef, disk string, size diskSize, timeout time.Duration) error {
+	err := pconf.Client.CheckVmRef(vmr)
+	if err != nil {
+		return err
+	}
+	params := map[string]interface{}{
+		"disk": disk,
+		"size": size.String(),
+	}
+	path := fmt.Sprintf("/nodes/%s/%s/%d/resize", vmr.Node(), vmr.GetVmType(), vmr.VmId())
+	return runTask(pconf, "PUT", path, params, timeout)
+}
+
+// deleteVmConfigKeys removes settings or devices, like net1, from the config of
+// a QEMU VM and waits for the config task. Removed disks become unused disks.
+func deleteVmConfigKeys(pconf *providerConfiguration, vmr *pxapi.VmRef, keys []string, timeout time.Duration) error {
+	err := pconf.Client.CheckVmRef(vmr)
+	if err != nil {
+		return err
+	}
+	params := map[string]interface{}{
+		"delete": strings.Join(keys, ","),
+	}
+	path := fmt.Sprintf("/nodes/%s/qemu/%d/config", vmr.Node(), vmr.VmId())
+	return runTask(pconf, "POST", path, params, timeout)
+}
+
+// deleteVm removes the VM or container, and its HA resource if any, and waits
+// for the destroy task. With purge, Proxmox also removes it from backup and
+// replication jobs.
+func deleteVm(pconf *providerConfiguration, vmr *pxapi.VmRef, purge bool, timeout time.Duration) error {
+	err := pconf.Client.CheckVmRef(vmr)
+	if err != nil {
+		return err
+	}
+	if vmr.HaState() != "" {
+		_, err = pconf.Session.Delete(fmt.Sprintf("/cluster/ha/resources/%d", vmr.VmId()), nil)
+		if err != nil {
+			return err
+		}
+	}
+	var params map[string]interface{}
+	if purge {
+		params = map[string]interface{}{"purge": true}
+	}
+	path := fmt.Sprintf("/nodes/%s/%s/%d", vmr.Node(), vmr.GetVmType(), vmr.VmId())
+	return runTask(pconf, "DELETE", path, params, timeout)
+}
@@ -0,0 +1,245 @@
+package proxmox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeApiServer answers Proxmox API requests with the handler registered for
+// their method and path, and with 404 for any other request.
+type fakeApiServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	handlers map[string]http.HandlerFunc
+	requests []string
+}
+
+func newFakeApiServer() *fakeApiServer {
+	s := &fakeApiServer{handlers: map[string]http.HandlerFunc{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// handle registers the handler of a request like "GET /nodes/pve/tasks".
+func (s *fakeApiServer) handle(request string, handler http.HandlerFunc) {
+	s.mu.Lock()
+	s.handlers[request] = handler
+	s.mu.Unlock()
+}
+
+func (s *fakeApiServer) serve(w http.ResponseWriter, r *http.Request) {
+	request := r.Method + " " + strings.TrimPrefix(r.URL.Path, "/api2/json")
+	// Handlers run one at a time, so they can share state without locking.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, request+"?"+r.URL.RawQuery)
+	handler := s.handlers[request]
+	if handler == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	handler(w, r)
+}
+
+// count returns how many of the requests so far were like "POST /nodes".
+func (s *fakeApiServer) count(request string) int {
+	n := 0
+	for _, sent := range s.history() {
+		if strings.HasPrefix(sent, request+"?") {
+			n++
+		}
+	}
+	return n
+}
+
+// history returns the requests received so far, with their query string.
+func (s *fakeApiServer) history() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *fakeApiServer) config() *providerConfiguration {
+	return &providerConfiguration{
+		Session: newApiSession(s.URL+"/api2/json", nil, retryPolicy{}),
+	}
+}
+
+func writeApiData(w http.ResponseWriter, data interface{}) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+const testUpid = "UPID:pve:0000C1F1:0001A2B3:5E0F1D2C:qmstart:100:root@pam:"
+
+func handleTaskStatus(server *fakeApiServer, exitStatus string) {
+	server.handle("GET /nodes/pve/tasks/"+testUpid+"/status", func(w http.ResponseWriter, r *http.Request) {
+		status := map[string]interface{}{"status": "running"}
+		if exitStatus != "" {
+			status = map[string]interface{}{"status": "stopped", "exitstatus": exitStatus}
+		}
+		writeApiData(w, status)
+	})
+}
+
+func TestWaitForTask(t *testing.T) {
+	tests := []struct {
+		name       string
+		exitStatus string
+		err        string
+	}{
+		{"ok", "OK", ""},
+		{"warnings", "WARNINGS: 2", ""},
+		{"failed", "storage 'local-lvm' does not exist", "storage 'local-lvm' does not exist"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeApiServer()
+			defer server.Close()
+			handleTaskStatus(server, test.exitStatus)
+
+			err := waitForTask(server.config().Session, testUpid, time.Minute)
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			taskErr, ok := err.(*taskError)
+			if !ok {
+				t.Fatalf("expected a taskError, got %v", err)
+			}
+			if taskErr.Upid != testUpid || taskErr.ExitStatus != test.err {
+				t.Errorf("expected task %s to fail with %q, got %+v", testUpid, test.err, taskErr)
+			}
+		})
+	}
+}
+
+func TestWaitForTaskLogTail(t *testing.T) {
+	server := newFakeApiServer()
+	defer server.Close()
+	handleTaskStatus(server, "command 'qm start 100' failed: exit code 1")
+	server.handle("GET /nodes/pve/tasks/"+testUpid+"/log", func(w http.ResponseWriter, r *http.Request) {
+		// The task logged 15 lines, of which only the last taskLogTail are
+		// asked for.
+		if r.URL.Query().Get("limit") == "1" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"total": 15, "data": []interface{}{}})
+			return
+		}
+		var lines []interface{}
+		if r.URL.Query().Get("start") == "5" {
+			for n := 5; n < 15; n++ {
+				lines = append(lines, map[string]interface{}{"n": n + 1, "t": fmt.Sprintf("line %d", n+1)})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"total": 15, "data": lines})
+	})
+
+	err := waitForTask(server.config().Session, testUpid, time.Minute)
+	if err == nil {
+		t.Fatal("expected the task to fail")
+	}
+	taskErr := err.(*taskError)
+	if len(taskErr.Log) != taskLogTail || taskErr.Log[0] != "line 6" || taskErr.Log[9] != "line 15" {
+		t.Errorf("expected the last %d log lines, got %q", taskLogTail, taskErr.Log)
+	}
+	if !strings.Contains(err.Error(), "exit code 1\nline 6\n") || !strings.HasSuffix(err.Error(), "line 15") {
+		t.Errorf("expected the error to end with the log tail, got %q", err.Error())
+	}
+}
+
+func TestWaitForTaskWithoutLog(t *testing.T) {
+	server := newFakeApiServer()
+	defer server.Close()
+	handleTaskStatus(server, "interrupted by signal")
+
+	// The log cannot be read, the task error is still returned.
+	err := waitForTask(server.config().Session, testUpid, time.Minute)
+	if taskErr, ok := err.(*taskError); !ok || taskErr.Log != nil || taskErr.Error() != "task "+testUpid+" failed: interrupted by signal" {
+		t.Errorf("expected a taskError without log, got %v", err)
+	}
+}
+
+func TestWaitForTaskTimeout(t *testing.T) {
+	server := newFakeApiServer()
+	defer server.Close()
+	handleTaskStatus(server, "")
+
+	err := waitForTask(server.config().Session, testUpid, 0)
+	if err == nil || !strings.HasPrefix(err.Error(), "Timeout after 0s waiting for task "+testUpid) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func TestWaitForTaskUpid(t *testing.T) {
+	server := newFakeApiServer()
+	defer server.Close()
+	session := server.config().Session
+
+	// Requests handled synchronously have no task to wait for.
+	if err := waitForTask(session, "", time.Minute); err != nil {
+		t.Errorf("expected no error without UPID, got %v", err)
+	}
+	err := waitForTask(session, "not a upid", time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "Invalid task UPID") {
+		t.Errorf("expected an invalid UPID error, got %v", err)
+	}
+	if requests := server.history(); len(requests) != 0 {
+		t.Errorf("expected no requests, got %q", requests)
+	}
+}
+
+func TestTaskNode(t *testing.T) {
+	tests := []struct {
+		upid  string
+		node  string
+		valid bool
+	}{
+		{testUpid, "pve", true},
+		{"UPID:node-2.example.com:00001234:0001A2B3:5E0F1D2C:vzdump::root@pam:", "node-2.example.com", true},
+		{"UPID::00001234", "", false},
+		{"pve:00001234", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		node, err := taskNode(test.upid)
+		if (err == nil) != test.valid || node != test.node {
+			t.Errorf("taskNode(%q): expected %q, valid %v, got %q, %v", test.upid, test.node, test.valid, node, err)
+		}
+	}
+}
+
+func TestRunTaskRetriesLockedTask(t *testing.T) {
+	server := newFakeApiServer()
+	defer server.Close()
+	var starts int
+	server.handle("POST /nodes/pve/qemu/100/status/start", func(w http.ResponseWriter, r *http.Request) {
+		starts++
+		writeApiData(w, testUpid)
+	})
+	server.handle("GET /nodes/pve/tasks/"+testUpid+"/status", func(w http.ResponseWriter, r *http.Request) {
+		// The first start fails on the config lock, the next one works.
+		exitStatus := "OK"
+		if starts == 1 {
+			exitStatus = "can't lock file '/var/lock/qemu-server/lock-100.conf' - got timeout"
+		}
+		writeApiData(w, map[string]interface{}{"status": "stopped", "exitstatus": exitStatus})
+	})
+
+	pconf := server.config()
+	pconf.Retry = retryPolicy{MaxRetries: 2, Backoff: time.Millisecond}
+	err := runTask(pconf, "POST", "/nodes/pve/qemu/100/status/start", nil, time.Minute)
+	if err != nil {
+		t.Fatalf("expected the task to succeed when started again: %v", err)
+	}
+	if n := server.count("POST /nodes/pve/qemu/100/status/start"); n != 2 {
+		t.Errorf("expected the task to be started twice, got %d", n)
+	}
+}
@@ -0,0 +1,100 @@
+package proxmox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+type tlsOptions struct {
+	Insecure          bool
+	CaFile            string
+	CaPem             string
+	ServerFingerprint string
+	ClientCertFile    string
+	ClientKeyFile     string
+}
+
+// tlsConfig builds the configuration used to connect to the Proxmox API. It
+// returns nil when the defaults of the http package are fine.
+func tlsConfig(opts tlsOptions) (*tls.Config, error) {
+	if opts == (tlsOptions{}) {
+		return nil, nil
+	}
+	tlsconf := &tls.Config{InsecureSkipVerify: opts.Insecure}
+
+	caPem := []byte(opts.CaPem)
+	if opts.CaFile != "" {
+		var err error
+		caPem, err = ioutil.ReadFile(opts.CaFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read pm_tls_ca_file: %v", err)
+		}
+	}
+	if len(caPem) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPem) {
+			return nil, errors.New("No PEM certificate found in the configured CA")
+		}
+		tlsconf.RootCAs = pool
+	}
+
+	if opts.ClientCertFile != "" || opts.ClientKeyFile != "" {
+		if opts.ClientCertFile == "" || opts.ClientKeyFile == "" {
+			return nil, errors.New("Both pm_tls_client_cert_file and pm_tls_client_key_file must be set to use a client certificate")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load client certificate: %v", err)
+		}
+		tlsconf.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts.ServerFingerprint != "" {
+		fingerprint, err := parseFingerprint(opts.ServerFingerprint)
+		if err != nil {
+			return nil, err
+		}
+		// A pinned certificate is trusted on its own, typically the self
+		// signed one of a node, unless a CA to verify it against is given.
+		if len(caPem) == 0 {
+			tlsconf.InsecureSkipVerify = true
+		}
+		tlsconf.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("Proxmox API did not present a certificate")
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			if !bytes.Equal(sum[:], fingerprint) {
+				return fmt.Errorf("Proxmox API certificate fingerprint %s does not match pm_tls_server_fingerprint", formatFingerprint(sum[:]))
+			}
+			return nil
+		}
+	}
+
+	return tlsconf, nil
+}
+
+// parseFingerprint accepts a SHA-256 fingerprint as shown by Proxmox
+// (AA:BB:...) or as plain hex.
+func parseFingerprint(fingerprint string) ([]byte, error) {
+	raw, err := hex.DecodeString(strings.Replace(fingerprint, ":", "", -1))
+	if err != nil || len(raw) != sha256.Size {
+		return nil, fmt.Errorf("Invalid SHA-256 fingerprint: %s", fingerprint)
+	}
+	return raw, nil
+}
+
+func formatFingerprint(fingerprint []byte) string {
+	parts := make([]string, len(fingerprint))
+	for i, b := range fingerprint {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
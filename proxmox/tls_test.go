@@ -0,0 +1,126 @@
+package proxmox
+
+import (
+	"crypto/sha256"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseFingerprint(t *testing.T) {
+	sum := sha256.Sum256([]byte("certificate"))
+	colons := formatFingerprint(sum[:])
+	tests := []struct {
+		name        string
+		fingerprint string
+		valid       bool
+	}{
+		{"proxmox format", colons, true},
+		{"lower case", strings.ToLower(colons), true},
+		{"plain hex", strings.Replace(colons, ":", "", -1), true},
+		{"sha1", "AB:CD:EF:01:23:45:67:89:AB:CD:EF:01:23:45:67:89:AB:CD:EF:01", false},
+		{"not hex", "ZZ" + colons[2:], false},
+		{"empty", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			raw, err := parseFingerprint(test.fingerprint)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("expected %q to be rejected", test.fingerprint)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if formatFingerprint(raw) != colons {
+				t.Errorf("expected %s, got %s", colons, formatFingerprint(raw))
+			}
+		})
+	}
+}
+
+func TestTlsConfigOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     tlsOptions
+		err      string
+		insecure bool
+	}{
+		{"defaults", tlsOptions{}, "", false},
+		{"insecure", tlsOptions{Insecure: true}, "", true},
+		{"invalid CA", tlsOptions{CaPem: "not a certificate"}, "No PEM certificate", false},
+		{"missing CA file", tlsOptions{CaFile: "/nonexistent/ca.pem"}, "pm_tls_ca_file", false},
+		{"client cert without key", tlsOptions{ClientCertFile: "client.pem"}, "Both pm_tls_client_cert_file and pm_tls_client_key_file", false},
+		{"client key without cert", tlsOptions{ClientKeyFile: "client.key"}, "Both pm_tls_client_cert_file and pm_tls_client_key_file", false},
+		{"invalid fingerprint", tlsOptions{ServerFingerprint: "AB:CD"}, "Invalid SHA-256 fingerprint", false},
+		{"fingerprint", tlsOptions{ServerFingerprint: strings.Repeat("AB", sha256.Size)}, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tlsconf, err := tlsConfig(test.opts)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.opts == (tlsOptions{}) {
+				if tlsconf != nil {
+					t.Error("expected no TLS config for the defaults")
+				}
+				return
+			}
+			if tlsconf.InsecureSkipVerify != test.insecure {
+				t.Errorf("expected InsecureSkipVerify %v, got %v", test.insecure, tlsconf.InsecureSkipVerify)
+			}
+		})
+	}
+}
+
+// TestTlsConfigServer connects to a server with a self signed certificate,
+// which is only trusted through its CA or its pinned fingerprint.
+func TestTlsConfigServer(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	cert := server.Certificate()
+	sum := sha256.Sum256(cert.Raw)
+	caPem := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	otherSum := sha256.Sum256([]byte("other certificate"))
+
+	tests := []struct {
+		name string
+		opts tlsOptions
+		ok   bool
+	}{
+		{"system CAs", tlsOptions{}, false},
+		{"CA", tlsOptions{CaPem: caPem}, true},
+		{"fingerprint", tlsOptions{ServerFingerprint: formatFingerprint(sum[:])}, true},
+		{"CA and fingerprint", tlsOptions{CaPem: caPem, ServerFingerprint: formatFingerprint(sum[:])}, true},
+		{"other fingerprint", tlsOptions{ServerFingerprint: formatFingerprint(otherSum[:])}, false},
+		{"insecure with other fingerprint", tlsOptions{Insecure: true, ServerFingerprint: formatFingerprint(otherSum[:])}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tlsconf, err := tlsConfig(test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsconf}}
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err == nil) != test.ok {
+				t.Errorf("expected success %v, got %v", test.ok, err)
+			}
+		})
+	}
+}
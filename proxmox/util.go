@@ -5,7 +5,9 @@ import (
 	"fmt"
 	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
 	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 func updateDeviceConfDefaults(
@@ -33,43 +35,92 @@ func updateDeviceConfDefaults(
 	return defaultDeviceConf
 }
 
-func DevicesSetToMapWithoutId(devicesSet *schema.Set) pxapi.QemuDevices {
-
-	devicesMap := pxapi.QemuDevices{}
-	i := 1
-	for _, set := range devicesSet.List() {
-		setMap, isMap := set.(map[string]interface{})
-		if isMap {
-			// setMap["id"] = i
-			devicesMap[i] = setMap
-			i += 1
+// expandLxcDevices is expandDevices for the mountpoints and networks of a
+// container. The id is only used as key, pxapi would send it as an option.
+func expandLxcDevices(devicesSet *schema.Set) pxapi.QemuDevices {
+	devicesMap := expandDevices(devicesSet)
+	for id, device := range devicesMap {
+		options := pxapi.QemuDevice{}
+		for key, value := range device {
+			if key != "id" {
+				options[key] = value
+			}
 		}
+		devicesMap[id] = options
 	}
 	return devicesMap
 }
 
-func AddIds(configSet *schema.Set) *schema.Set {
-	// add device config ids
-	var i = 1
-	for _, setConf := range configSet.List() {
-		configSet.Remove(setConf)
-		setConfMap := setConf.(map[string]interface{})
-		setConfMap["id"] = i
-		i += 1
-		configSet.Add(setConfMap)
+// removedDeviceKeys returns the keys, like mp0, of the devices of oldSet which
+// are not in newSet.
+func removedDeviceKeys(prefix string, oldSet *schema.Set, newSet *schema.Set) []string {
+	newDevices := expandDevices(newSet)
+	var keys []string
+	for id := range expandDevices(oldSet) {
+		// Negative ids are devices of upgraded state which were not found.
+		if _, ok := newDevices[id]; !ok && id >= 0 {
+			keys = append(keys, fmt.Sprintf("%s%d", prefix, id))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// apiListItem returns the item of the list at path whose key is id, or nil
+// when there is no such item. Looking items up in a list tells a missing item
+// apart from other errors, which Proxmox reports alike.
+func apiListItem(session *apiSession, path string, key string, id string) (map[string]interface{}, error) {
+	resp, err := session.Get(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	items, _ := resp["data"].([]interface{})
+	for _, item := range items {
+		item, _ := item.(map[string]interface{})
+		if item[key] == id {
+			return item, nil
+		}
+	}
+	return nil, nil
+}
+
+// apiString and apiInt read a value of the API, which returns numbers both as
+// JSON numbers and as strings.
+func apiString(value interface{}) string {
+	if value == nil {
+		return ""
 	}
-	return configSet
+	return fmt.Sprint(value)
 }
 
-func RemoveIds(configSet *schema.Set) *schema.Set {
-	// remove device config ids
-	for _, setConf := range configSet.List() {
-		configSet.Remove(setConf)
-		setConfMap := setConf.(map[string]interface{})
-		delete(setConfMap, "id")
-		configSet.Add(setConfMap)
+func apiInt(value interface{}) int {
+	switch value := value.(type) {
+	case float64:
+		return int(value)
+	case string:
+		n, _ := strconv.Atoi(value)
+		return n
+	}
+	return 0
+}
+
+// apiStringList reads a list of the API, which is either a JSON array or a
+// comma separated string.
+func apiStringList(value interface{}) []string {
+	var list []string
+	switch value := value.(type) {
+	case []interface{}:
+		for _, item := range value {
+			list = append(list, apiString(item))
+		}
+	case string:
+		for _, item := range strings.Split(value, ",") {
+			if item != "" {
+				list = append(list, item)
+			}
+		}
 	}
-	return configSet
+	return list
 }
 
 // TODO for debug
@@ -0,0 +1,93 @@
+package proxmox
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+// testDeviceSet returns the set of a device block of a resource schema.
+func testDeviceSet(t *testing.T, resourceSchema map[string]*schema.Schema, key string, devices ...map[string]interface{}) *schema.Set {
+	list := []interface{}{}
+	for _, device := range devices {
+		list = append(list, device)
+	}
+	raw := map[string]interface{}{key: list}
+	return schema.TestResourceDataRaw(t, resourceSchema, raw).Get(key).(*schema.Set)
+}
+
+func TestRemovedDeviceKeys(t *testing.T) {
+	net := func(id int, bridge string) map[string]interface{} {
+		return map[string]interface{}{"id": id, "model": "virtio", "bridge": bridge}
+	}
+	serial := func(id int) map[string]interface{} {
+		return map[string]interface{}{"id": id, "type": "socket"}
+	}
+	mountpoint := func(id int, mp string) map[string]interface{} {
+		return map[string]interface{}{"id": id, "volume": "local-lvm:8", "mp": mp}
+	}
+	lxcSchema := resourceLxc().Schema
+
+	tests := []struct {
+		name     string
+		prefix   string
+		oldSet   *schema.Set
+		newSet   *schema.Set
+		expected []string
+	}{
+		{
+			"removed nets",
+			"net",
+			testDeviceSet(t, resourceQemuSchema, "network", net(0, "vmbr0"), net(1, "vmbr1"), net(2, "vmbr2")),
+			testDeviceSet(t, resourceQemuSchema, "network", net(1, "vmbr1")),
+			[]string{"net0", "net2"},
+		},
+		{
+			// A changed device is updated rather than removed.
+			"changed net",
+			"net",
+			testDeviceSet(t, resourceQemuSchema, "network", net(0, "vmbr0")),
+			testDeviceSet(t, resourceQemuSchema, "network", net(0, "vmbr1")),
+			nil,
+		},
+		{
+			"removed serial",
+			"serial",
+			testDeviceSet(t, resourceQemuSchema, "serial", serial(0), serial(3)),
+			testDeviceSet(t, resourceQemuSchema, "serial", serial(0)),
+			[]string{"serial3"},
+		},
+		{
+			"all serials removed",
+			"serial",
+			testDeviceSet(t, resourceQemuSchema, "serial", serial(0)),
+			testDeviceSet(t, resourceQemuSchema, "serial"),
+			[]string{"serial0"},
+		},
+		{
+			// Upgraded state has id -1 for devices not found in the
+			// container, which have no key to delete.
+			"negative upgrade ids",
+			"mp",
+			testDeviceSet(t, lxcSchema, "mountpoint", mountpoint(-1, "/opt"), mountpoint(1, "/srv")),
+			testDeviceSet(t, lxcSchema, "mountpoint"),
+			[]string{"mp1"},
+		},
+		{
+			"negative id set",
+			"mp",
+			testDeviceSet(t, lxcSchema, "mountpoint", mountpoint(-1, "/opt")),
+			testDeviceSet(t, lxcSchema, "mountpoint", mountpoint(2, "/opt")),
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keys := removedDeviceKeys(test.prefix, test.oldSet, test.newSet)
+			if !reflect.DeepEqual(keys, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, keys)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package proxmox
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/helper/validation"
+)
+
+var (
+	rxDnsName       = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+	rxInterfaceName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]{0,14}$`)
+	rxBootOrder     = regexp.MustCompile(`^([acdn]{1,4}|order=[a-z0-9]+(;[a-z0-9]+)*)$`)
+	rxBootDisk      = regexp.MustCompile(`^(ide|sata|scsi|virtio)[0-9]+$`)
+	rxCpuType       = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+	rxSerialType    = regexp.MustCompile(`^(socket|/dev/.+)$`)
+	rxVolumeId      = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*:.+$`)
+	rxVlanTrunks    = regexp.MustCompile(`^[0-9]+(-[0-9]+)?(;[0-9]+(-[0-9]+)?)*$`)
+	rxMountFsTypes  = regexp.MustCompile(`^[a-z0-9]+(;[a-z0-9]+)*$`)
+	rxMountOptions  = regexp.MustCompile(`^(noatime|nodev|noexec|nosuid)(;(noatime|nodev|noexec|nosuid))*$`)
+	rxUserId        = regexp.MustCompile(`^[^\s:/@]+@[a-zA-Z][a-zA-Z0-9._-]*$`)
+	rxAccessId      = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+	rxTokenId       = regexp.MustCompile(`^[^\s:/@]+@[a-zA-Z][a-zA-Z0-9._-]*![a-zA-Z][a-zA-Z0-9._-]*$`)
+)
+
+var (
+	// Valid VLAN tags, or -1 for none.
+	validateVlanTag = validation.Any(validation.IntInSlice([]int{-1}), validation.IntBetween(1, 4094))
+	validateVmId    = validation.IntBetween(minVmId, maxVmId)
+	validateDnsName = validation.StringMatch(rxDnsName, "must be a valid DNS name")
+	validateBridge  = validation.StringMatch(rxInterfaceName, "must be a network interface name, like vmbr0")
+	validateUserId  = validation.StringMatch(rxUserId, "must be a user name and realm, like terraform@pve")
+	// Group, role and token names.
+	validateAccessId = validation.StringMatch(rxAccessId, "must only contain letters, digits, _, . and -")
+)
+
+// validateHotplug checks a hotplug setting: 0, 1, or a comma separated list of
+// devices.
+func validateHotplug(i interface{}, k string) ([]string, []error) {
+	v, ok := i.(string)
+	if !ok {
+		return nil, []error{fmt.Errorf("expected type of %s to be string", k)}
+	}
+	if v == "0" || v == "1" {
+		return nil, nil
+	}
+	for _, device := range strings.Split(v, ",") {
+		switch device {
+		case "network", "disk", "cpu", "memory", "usb":
+		default:
+			return nil, []error{fmt.Errorf("%s: unknown hotplug device %q, expected 0, 1 or a list of network, disk, cpu, memory and usb", k, device)}
+		}
+	}
+	return nil, nil
+}
+
+// validateStartup checks a startup setting like order=1,up=30,down=60.
+func validateStartup(i interface{}, k string) ([]string, []error) {
+	v, ok := i.(string)
+	if !ok {
+		return nil, []error{fmt.Errorf("expected type of %s to be string", k)}
+	}
+	for _, part := range strings.Split(v, ",") {
+		keyValue := strings.SplitN(part, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, []error{fmt.Errorf("%s: expected key=value, got %q", k, part)}
+		}
+		switch keyValue[0] {
+		case "order", "up", "down":
+		default:
+			return nil, []error{fmt.Errorf("%s: unknown key %q, expected order, up or down", k, keyValue[0])}
+		}
+		if n, err := strconv.Atoi(keyValue[1]); err != nil || n < 0 {
+			return nil, []error{fmt.Errorf("%s: %s must be a non-negative number, got %q", k, keyValue[0], keyValue[1])}
+		}
+	}
+	return nil, nil
+}
+
+// validateNameservers checks a space separated list of IP addresses.
+func validateNameservers(i interface{}, k string) ([]string, []error) {
+	v, ok := i.(string)
+	if !ok {
+		return nil, []error{fmt.Errorf("expected type of %s to be string", k)}
+	}
+	for _, nameserver := range strings.Fields(v) {
+		if net.ParseIP(nameserver) == nil {
+			return nil, []error{fmt.Errorf("%s: %q is not an IP address", k, nameserver)}
+		}
+	}
+	return nil, nil
+}
+
+// validateIpOrKeyword accepts an address in CIDR notation or one of keywords,
+// like dhcp.
+func validateIpOrKeyword(keywords ...string) schema.SchemaValidateFunc {
+	return validation.Any(validation.IsCIDR, validation.StringInSlice(keywords, false))
+}
+
+// validateMtu checks an MTU given as a string.
+func validateMtu(i interface{}, k string) ([]string, []error) {
+	v, ok := i.(string)
+	if !ok {
+		return nil, []error{fmt.Errorf("expected type of %s to be string", k)}
+	}
+	if n, err := strconv.Atoi(v); err != nil || n < 64 || n > 65535 {
+		return nil, []error{fmt.Errorf("%s: must be a number between 64 and 65535, got %q", k, v)}
+	}
+	return nil, nil
+}
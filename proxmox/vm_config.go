@@ -0,0 +1,329 @@
+package proxmox
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+)
+
+// pxapi waits for the tasks of ConfigQemu.CreateVm, ConfigQemu.UpdateConfig
+// and ConfigLxc.CreateLxc for its fixed TaskTimeout of 5 minutes. The
+// functions below send the same parameters and wait until the timeout of the
+// resource operation instead.
+
+var (
+	rxQemuDiskKey = regexp.MustCompile(`^(ide|sata|scsi|virtio)[0-9]+$`)
+	// The volume of a disk on a directory storage, like 100/vm-100-disk-0.raw.
+	rxDirVolume = regexp.MustCompile(`^[0-9]+/(\S+\.\S+)$`)
+)
+
+// deviceOptions formats the options of a device like pxapi does: true is sent
+// as 1, while false, empty strings and zero are left out. Numbers decoded from
+// JSON are float64.
+func deviceOptions(device map[string]interface{}) []string {
+	keys := make([]string, 0, len(device))
+	for key := range device {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var options []string
+	for _, key := range keys {
+		switch value := device[key].(type) {
+		case bool:
+			if value {
+				options = append(options, key+"=1")
+			}
+		case string:
+			if value != "" {
+				options = append(options, key+"="+value)
+			}
+		case int:
+			if value > 0 {
+				options = append(options, key+"="+strconv.Itoa(value))
+			}
+		case float64:
+			if value > 0 {
+				options = append(options, key+"="+strconv.FormatFloat(value, 'f', -1, 64))
+			}
+		}
+	}
+	return options
+}
+
+// qemuConfigParams returns the parameters of a VM config shared by creating
+// and updating it, and the ones to delete because they are unset.
+func qemuConfigParams(config pxapi.ConfigQemu, vmID int) (map[string]interface{}, []string) {
+	params := map[string]interface{}{
+		"name":        config.Name,
+		"description": config.Description,
+		"onboot":      config.Onboot,
+		"agent":       config.Agent,
+		"sockets":     config.QemuSockets,
+		"cores":       config.QemuCores,
+		"cpu":         config.QemuCpu,
+		"numa":        config.QemuNuma,
+		"hotplug":     config.Hotplug,
+		"memory":      config.Memory,
+		"boot":        config.Boot,
+	}
+	var deleted []string
+	if config.Bios != "" {
+		params["bios"] = config.Bios
+	}
+	if config.Balloon >= 1 {
+		params["balloon"] = config.Balloon
+	} else {
+		deleted = append(deleted, "balloon")
+	}
+	if config.QemuVcpus >= 1 {
+		params["vcpus"] = config.QemuVcpus
+	} else {
+		deleted = append(deleted, "vcpus")
+	}
+	if config.BootDisk != "" {
+		params["bootdisk"] = config.BootDisk
+	}
+	if config.Scsihw != "" {
+		params["scsihw"] = config.Scsihw
+	}
+	if vga := deviceOptions(config.QemuVga); len(vga) > 0 {
+		params["vga"] = strings.Join(vga, ",")
+	} else {
+		deleted = append(deleted, "vga")
+	}
+	config.CreateQemuNetworksParams(vmID, params)
+	config.CreateQemuSerialsParams(vmID, params)
+	return params, deleted
+}
+
+// createQemuDisk allocates the volume of a disk parameter, like
+// media=disk,size=10G,file=local-lvm:vm-100-disk-1, before it is written to
+// the config, as pxapi does. It returns the volume, or "" for a cdrom.
+func createQemuDisk(pconf *providerConfiguration, node string, vmID int, disk string) (string, error) {
+	options := map[string]string{}
+	for _, option := range strings.Split(disk, ",") {
+		keyValue := strings.SplitN(option, "=", 2)
+		if len(keyValue) == 2 {
+			options[keyValue[0]] = keyValue[1]
+		}
+	}
+	if options["media"] != "disk" {
+		return "", nil
+	}
+	volume := options["file"]
+	storageVolume := strings.SplitN(volume, ":", 2)
+	if len(storageVolume) != 2 {
+		return "", fmt.Errorf("Invalid disk volume: %s", volume)
+	}
+	fileName := storageVolume[1]
+	if match := rxDirVolume.FindStringSubmatch(fileName); match != nil {
+		fileName = match[1]
+	}
+	params := map[string]interface{}{
+		"vmid":     vmID,
+		"filename": fileName,
+		"size":     options["size"],
+	}
+	contentPath := fmt.Sprintf("/nodes/%s/storage/%s/content", node, url.PathEscape(storageVolume[0]))
+	resp, err := pconf.Session.Post(contentPath, params)
+	if err != nil {
+		return "", err
+	}
+	if created, _ := resp["data"].(string); created != volume {
+		return "", fmt.Errorf("Cannot create VM disk %s", volume)
+	}
+	return volume, nil
+}
+
+// createQemuDisks allocates the volumes of the disk parameters of a VM. It
+// returns the volumes created so far, also on errors.
+func createQemuDisks(pconf *providerConfiguration, node string, vmID int, params map[string]interface{}) ([]string, error) {
+	var volumes []string
+	for key, value := range params {
+		if !rxQemuDiskKey.MatchString(key) {
+			continue
+		}
+		volume, err := createQemuDisk(pconf, node, vmID, value.(string))
+		if err != nil {
+			return volumes, err
+		}
+		if volume != "" {
+			volumes = append(volumes, volume)
+		}
+	}
+	return volumes, nil
+}
+
+// createVm creates the VM of an iso build with the parameters of pxapi's
+// ConfigQemu.CreateVm.
+func createVm(pconf *providerConfiguration, config pxapi.ConfigQemu, vmr *pxapi.VmRef, timeout time.Duration) error {
+	if config.HasCloudInit() {
+		return fmt.Errorf("Cloud-init parameters only supported on clones or updates")
+	}
+	vmr.SetVmType("qemu")
+	params, _ := qemuConfigParams(config, vmr.VmId())
+	params["vmid"] = vmr.VmId()
+	params["ide2"] = config.QemuIso + ",media=cdrom"
+	params["ostype"] = config.QemuOs
+	if vmr.Pool() != "" {
+		params["pool"] = vmr.Pool()
+	}
+	config.CreateQemuDisksParams(vmr.VmId(), params, false)
+
+	volumes, err := createQemuDisks(pconf, vmr.Node(), vmr.VmId(), params)
+	if err == nil {
+		err = runTask(pconf, "POST", fmt.Sprintf("/nodes/%s/qemu", vmr.Node()), params, timeout)
+		switch err.(type) {
+		case nil, *taskError, *apiError:
+		default:
+			// The task may still be creating the VM, which uses the volumes.
+			return fmt.Errorf("Error creating VM: %v", err)
+		}
+	}
+	if err != nil {
+		// Without a VM, nothing would delete the volumes.
+		for _, volume := range volumes {
+			storage := strings.SplitN(volume, ":", 2)[0]
+			if deleteErr := deleteStorageContent(pconf, vmr.Node(), storage, volume, time.Minute); deleteErr != nil {
+				log.Printf("[WARN] could not delete volume %s of VM %d: %v", volume, vmr.VmId(), deleteErr)
+			}
+		}
+		return fmt.Errorf("Error creating VM: %v", err)
+	}
+	_, err = pconf.Client.UpdateVMHA(vmr, config.HaState)
+	return err
+}
+
+// updateVmConfig writes the config of a VM with the parameters of pxapi's
+// ConfigQemu.UpdateConfig, which also sets the HA state and moves the VM to
+// the pool of the config.
+func updateVmConfig(pconf *providerConfiguration, config pxapi.ConfigQemu, vmr *pxapi.VmRef, timeout time.Duration) error {
+	err := pconf.Client.CheckVmRef(vmr)
+	if err != nil {
+		return err
+	}
+	params, deleted := qemuConfigParams(config, vmr.VmId())
+
+	// The first disk belongs to the clone or template, the volumes of the
+	// others are created when missing.
+	disks := map[string]interface{}{}
+	config.CreateQemuDisksParams(vmr.VmId(), disks, true)
+	for key, value := range disks {
+		if _, err := createQemuDisk(pconf, vmr.Node(), vmr.VmId(), value.(string)); err != nil {
+			log.Printf("[DEBUG] not creating the volume of %s: %v", key, err)
+		}
+		params[key] = value
+	}
+
+	if config.CIuser != "" {
+		params["ciuser"] = config.CIuser
+	}
+	if config.CIpassword != "" {
+		params["cipassword"] = config.CIpassword
+	}
+	if config.CIcustom != "" {
+		params["cicustom"] = config.CIcustom
+	}
+	if config.Searchdomain != "" {
+		params["searchdomain"] = config.Searchdomain
+	}
+	if config.Nameserver != "" {
+		params["nameserver"] = config.Nameserver
+	}
+	if config.Sshkeys != "" {
+		// Proxmox expects the keys URL encoded, once more than the form.
+		sshkeys := url.PathEscape(config.Sshkeys + "\n")
+		sshkeys = strings.NewReplacer("+", "%2B", "@", "%40", "=", "%3D").Replace(sshkeys)
+		params["sshkeys"] = sshkeys
+	}
+	if config.Ipconfig0 != "" {
+		params["ipconfig0"] = config.Ipconfig0
+	}
+	if config.Ipconfig1 != "" {
+		params["ipconfig1"] = config.Ipconfig1
+	}
+	if config.Ipconfig2 != "" {
+		params["ipconfig2"] = config.Ipconfig2
+	}
+	if len(deleted) > 0 {
+		params["delete"] = strings.Join(deleted, ",")
+	}
+
+	path := fmt.Sprintf("/nodes/%s/%s/%d/config", vmr.Node(), vmr.GetVmType(), vmr.VmId())
+	err = runTask(pconf, "POST", path, params, timeout)
+	if err != nil {
+		return err
+	}
+	_, err = pconf.Client.UpdateVMHA(vmr, config.HaState)
+	if err != nil {
+		return err
+	}
+	return moveVmPool(pconf, vmr.VmId(), vmr.Pool(), config.Pool)
+}
+
+// lxcConfigParams returns the parameters of a pxapi ConfigLxc, whose type is
+// not exported, with its devices formatted like pxapi's ConfigLxc.CreateLxc
+// does.
+func lxcConfigParams(config interface{}) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var params map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(encoded)))
+	decoder.UseNumber()
+	err = decoder.Decode(&params)
+	if err != nil {
+		return nil, err
+	}
+
+	features, _ := params["features"].(map[string]interface{})
+	params["features"] = strings.Join(deviceOptions(jsonNumbers(features)), ",")
+	for key, prefix := range map[string]string{"mountpoints": "mp", "networks": "net"} {
+		devices, _ := params[key].(map[string]interface{})
+		for id, device := range devices {
+			device, _ := device.(map[string]interface{})
+			params[prefix+id] = strings.Join(deviceOptions(jsonNumbers(device)), ",")
+		}
+		delete(params, key)
+	}
+	unused, _ := params["unused"].([]interface{})
+	for id, volume := range unused {
+		params[fmt.Sprintf("unused%d", id)] = volume
+	}
+	delete(params, "unused")
+	return params, nil
+}
+
+// jsonNumbers converts the json.Number values of a decoded device into the
+// float64 deviceOptions knows.
+func jsonNumbers(device map[string]interface{}) map[string]interface{} {
+	for key, value := range device {
+		if number, ok := value.(json.Number); ok {
+			device[key], _ = number.Float64()
+		}
+	}
+	return device
+}
+
+// createLxc creates a container with the parameters of pxapi's
+// ConfigLxc.CreateLxc. Its task downloads and extracts the template, or
+// restores a backup, which can take longer than pxapi waits.
+func createLxc(pconf *providerConfiguration, config interface{}, vmr *pxapi.VmRef, timeout time.Duration) error {
+	vmr.SetVmType("lxc")
+	params, err := lxcConfigParams(config)
+	if err != nil {
+		return err
+	}
+	params["vmid"] = vmr.VmId()
+	return runTask(pconf, "POST", fmt.Sprintf("/nodes/%s/lxc", vmr.Node()), params, timeout)
+}
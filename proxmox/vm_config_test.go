@@ -0,0 +1,246 @@
+package proxmox
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+)
+
+func TestDeviceOptions(t *testing.T) {
+	device := map[string]interface{}{
+		"name":     "eth0",
+		"firewall": true,
+		"link":     false,
+		"tag":      10,
+		"mtu":      0,
+		"rate":     float64(12.5),
+		"size":     float64(1048576),
+		"hwaddr":   "",
+		"unknown":  []string{"x"},
+	}
+	expected := []string{"firewall=1", "name=eth0", "rate=12.5", "size=1048576", "tag=10"}
+	if options := deviceOptions(device); !reflect.DeepEqual(options, expected) {
+		t.Errorf("expected %q, got %q", expected, options)
+	}
+	if options := deviceOptions(nil); len(options) != 0 {
+		t.Errorf("expected no options, got %q", options)
+	}
+}
+
+func TestLxcConfigParams(t *testing.T) {
+	config := pxapi.NewConfigLxc()
+	config.Ostemplate = "local:vztmpl/debian-10.0-standard_10.0-1_amd64.tar.gz"
+	config.Hostname = "web-1"
+	config.Memory = 1048576
+	config.Features = pxapi.QemuDevice{"nesting": true, "mount": ""}
+	config.Networks = pxapi.QemuDevices{
+		0: {"name": "eth0", "bridge": "vmbr0", "tag": 10, "firewall": false},
+	}
+	config.Mountpoints = pxapi.QemuDevices{
+		1: {"volume": "local-lvm:8", "mp": "/data"},
+	}
+	config.Unused = []string{"local-lvm:vm-100-disk-3"}
+
+	params, err := lxcConfigParams(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"ostemplate": "local:vztmpl/debian-10.0-standard_10.0-1_amd64.tar.gz",
+		"hostname":   "web-1",
+		"memory":     "1048576",
+		"features":   "nesting=1",
+		"net0":       "bridge=vmbr0,name=eth0,tag=10",
+		"mp1":        "mp=/data,volume=local-lvm:8",
+		"unused0":    "local-lvm:vm-100-disk-3",
+	}
+	for key, value := range expected {
+		if got := apiString(params[key]); got != value {
+			t.Errorf("%s: expected %q, got %q", key, value, got)
+		}
+	}
+	for _, key := range []string{"networks", "mountpoints", "unused"} {
+		if _, ok := params[key]; ok {
+			t.Errorf("expected %s to be replaced by its devices", key)
+		}
+	}
+}
+
+// newVmConfigServer fakes a node which creates volumes and runs the tasks
+// of VM config changes with the exit status, and records the form of the
+// last request to path.
+func newVmConfigServer(path string, exitStatus string, form *url.Values) *fakeApiServer {
+	server := newFakeApiServer()
+	server.handle("POST /nodes/pve/storage/local-lvm/content", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		writeApiData(w, "local-lvm:"+r.PostForm.Get("filename"))
+	})
+	server.handle("DELETE /nodes/pve/storage/local-lvm/content/local-lvm:vm-100-disk-0", func(w http.ResponseWriter, r *http.Request) {
+		writeApiData(w, nil)
+	})
+	server.handle(path, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		*form = r.PostForm
+		writeApiData(w, testUpid)
+	})
+	handleTaskStatus(server, exitStatus)
+	return server
+}
+
+func testIsoConfig() pxapi.ConfigQemu {
+	return pxapi.ConfigQemu{
+		Name:        "web-1",
+		Memory:      2048,
+		QemuCores:   2,
+		QemuSockets: 1,
+		QemuOs:      "l26",
+		QemuIso:     "local:iso/debian-10.iso",
+		QemuDisks: pxapi.QemuDevices{
+			0: {"type": "virtio", "storage": "local-lvm", "storage_type": "lvm", "size": "10G", "cache": "none"},
+		},
+	}
+}
+
+func TestCreateVm(t *testing.T) {
+	var form url.Values
+	server := newVmConfigServer("POST /nodes/pve/qemu", "OK", &form)
+	defer server.Close()
+	vmr := pxapi.NewVmRef(100)
+	vmr.SetNode("pve")
+
+	err := createVm(server.config(), testIsoConfig(), vmr, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The form was written by the handler, under the lock of the server.
+	server.mu.Lock()
+	sent := form
+	server.mu.Unlock()
+	if sent.Get("vmid") != "100" || sent.Get("ide2") != "local:iso/debian-10.iso,media=cdrom" || sent.Get("ostype") != "l26" {
+		t.Errorf("expected the VM parameters, got %v", sent)
+	}
+	if !strings.Contains(sent.Get("virtio0"), "file=local-lvm:vm-100-disk-0") {
+		t.Errorf("expected the created disk, got %q", sent.Get("virtio0"))
+	}
+	if vmr.GetVmType() != "qemu" {
+		t.Errorf("expected the VM type to be set, got %q", vmr.GetVmType())
+	}
+}
+
+func TestCreateVmFailed(t *testing.T) {
+	tests := []struct {
+		name       string
+		exitStatus string
+		timeout    time.Duration
+		deleted    bool
+	}{
+		// The volumes of a VM which failed to be created are deleted.
+		{"task failed", "unable to create VM 100 - no such bridge 'vmbr9'", time.Minute, true},
+		// The task may still create the VM after the timeout.
+		{"timeout", "", 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var form url.Values
+			server := newVmConfigServer("POST /nodes/pve/qemu", test.exitStatus, &form)
+			defer server.Close()
+			vmr := pxapi.NewVmRef(100)
+			vmr.SetNode("pve")
+
+			if err := createVm(server.config(), testIsoConfig(), vmr, test.timeout); err == nil {
+				t.Fatal("expected an error")
+			}
+			deleted := server.count("DELETE /nodes/pve/storage/local-lvm/content/local-lvm:vm-100-disk-0") == 1
+			if deleted != test.deleted {
+				t.Errorf("expected the volume to be deleted: %v, got %q", test.deleted, server.history())
+			}
+		})
+	}
+}
+
+func TestCreateVmCloudInit(t *testing.T) {
+	server := newFakeApiServer()
+	defer server.Close()
+	config := testIsoConfig()
+	config.CIuser = "debian"
+
+	if err := createVm(server.config(), config, pxapi.NewVmRef(100), time.Minute); err == nil {
+		t.Error("expected an error for cloud-init parameters")
+	}
+	if requests := server.history(); len(requests) != 0 {
+		t.Errorf("expected no requests, got %q", requests)
+	}
+}
+
+func TestUpdateVmConfig(t *testing.T) {
+	var form url.Values
+	server := newVmConfigServer("POST /nodes/pve/qemu/100/config", "OK", &form)
+	defer server.Close()
+	server.handle("PUT /pools/web", func(w http.ResponseWriter, r *http.Request) {
+		writeApiData(w, nil)
+	})
+	vmr := pxapi.NewVmRef(100)
+	vmr.SetNode("pve")
+	vmr.SetVmType("qemu")
+	config := pxapi.ConfigQemu{
+		Name:    "web-1",
+		Memory:  2048,
+		Pool:    "web",
+		CIuser:  "debian",
+		Sshkeys: "ssh-ed25519 AAAA+b/c= user@host",
+		QemuDisks: pxapi.QemuDevices{
+			0: {"type": "scsi", "storage": "local-lvm", "storage_type": "lvm", "size": "10G", "cache": "none"},
+			1: {"type": "scsi", "storage": "local-lvm", "storage_type": "lvm", "size": "20G", "cache": "none"},
+		},
+		Ipconfig0: "ip=dhcp",
+	}
+
+	err := updateVmConfig(server.config(), config, vmr, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The form was written by the handler, under the lock of the server.
+	server.mu.Lock()
+	sent := form
+	server.mu.Unlock()
+	expected := map[string]string{
+		"name":      "web-1",
+		"ciuser":    "debian",
+		"sshkeys":   "ssh-ed25519%20AAAA%2Bb%2Fc%3D%20user%40host%0A",
+		"ipconfig0": "ip=dhcp",
+		"delete":    "balloon,vcpus,vga",
+	}
+	for key, value := range expected {
+		if sent.Get(key) != value {
+			t.Errorf("%s: expected %q, got %q", key, value, sent.Get(key))
+		}
+	}
+	// The first disk is the one of the clone.
+	if _, ok := sent["scsi0"]; ok || !strings.Contains(sent.Get("scsi1"), "file=local-lvm:vm-100-disk-1") {
+		t.Errorf("expected only the second disk, got %v", sent)
+	}
+	for _, request := range []string{"POST /nodes/pve/storage/local-lvm/content", "PUT /pools/web"} {
+		if n := server.count(request); n != 1 {
+			t.Errorf("expected %s once, got %d times", request, n)
+		}
+	}
+}
+
+func TestUpdateVmConfigTimeout(t *testing.T) {
+	var form url.Values
+	server := newVmConfigServer("POST /nodes/pve/qemu/100/config", "", &form)
+	defer server.Close()
+	vmr := pxapi.NewVmRef(100)
+	vmr.SetNode("pve")
+	vmr.SetVmType("qemu")
+
+	err := updateVmConfig(server.config(), pxapi.ConfigQemu{Name: "web-1"}, vmr, 0)
+	if err == nil || !strings.HasPrefix(err.Error(), "Timeout after 0s waiting for task") {
+		t.Errorf("expected the config task to time out, got %v", err)
+	}
+}